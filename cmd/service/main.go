@@ -1,32 +1,429 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/lbrictson/wazuh-jumpcloud-integration/pkg"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// version is set at build time with -ldflags "-X main.version=x.y.z"
+var version = "dev"
+
+// Exit codes, the Wazuh command wodle records the exit code of every run in ossec.log
+const (
+	exitOK         = 0
+	exitFailure    = 1
+	exitUsage      = 2
+	exitConfig     = 3
+	exitConnection = 4
+)
+
+// command is a single subcommand of the CLI
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "run", summary: "Collect events once and exit, used by the Wazuh command wodle", run: runCommand},
+		{name: "daemon", summary: "Collect events on an interval until stopped", run: daemonCommand},
+		{name: "backfill", summary: "Collect a historical window of events without touching the checkpoint", run: backfillCommand},
+		{name: "validate-config", summary: "Check the config and state files can be read", run: validateConfigCommand},
+		{name: "test-connection", summary: "Check JumpCloud accepts the configured API key", run: testConnectionCommand},
+		{name: "version", summary: "Print the version and exit", run: versionCommand},
+	}
+}
+
 func main() {
-	// Panic if no arguments are provided
-	if len(os.Args) < 3 {
-		fmt.Println("Expected path to config file as argument but no path was provided.  Usage: wazuh-jumpcloud-integration <path to config file>.json <path to log file>")
-		os.Exit(1)
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// dispatch runs the subcommand named by the first argument and returns the exit code
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitUsage
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage()
+		return exitOK
+	}
+	// Older versions took the config and output paths as the only arguments, keep existing wodle commands working
+	if len(args) == 2 && !strings.HasPrefix(args[0], "-") {
+		return runCommand([]string{"--config", args[0], "--output", args[1]})
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+	printUsage()
+	return exitUsage
+}
+
+func printUsage() {
+	fmt.Println("Usage: wazuh-jumpcloud-integration <command> [flags]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-16s %v\n", c.name, c.summary)
+	}
+	fmt.Println()
+	fmt.Println("Run wazuh-jumpcloud-integration <command> --help for the flags of a command")
+}
+
+// options are the flags shared by the subcommands, each subcommand registers the ones it uses
+type options struct {
+	configPath string
+	statePath  string
+	outputPath string
+	logLevel   string
+	dryRun     bool
+	timeout    time.Duration
+}
+
+func newFlagSet(name string, summary string) (*flag.FlagSet, *options) {
+	o := options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wazuh-jumpcloud-integration %v [flags]\n\n%v\n\nFlags:\n", name, summary)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.configPath, "config", "", "path to the config file (required)")
+	fs.StringVar(&o.logLevel, "log-level", "info", "lowest level of message to print: debug, info, warn or error")
+	return fs, &o
+}
+
+func (o *options) addStateFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.statePath, "state", "", "path to the state file, overrides state_file in the config")
+}
+
+func (o *options) addOutputFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.outputPath, "output", "", "path to the file events are appended to, replaces the outputs in the config")
+}
+
+func (o *options) addTimeoutFlag(fs *flag.FlagSet, value time.Duration) {
+	fs.DurationVar(&o.timeout, "timeout", value, "give up after this long, 0 waits for as long as it takes")
+}
+
+// signalContext returns a context that is cancelled on SIGTERM or SIGINT and after the timeout if it is above zero
+func signalContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func (o *options) addDryRunFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print events to stdout instead of the outputs and do not update the state file")
+}
+
+// parse parses the flags and applies the log level, it returns an exit code and false if the command should stop
+func (o *options) parse(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return exitUsage, false
+	}
+	if o.configPath == "" {
+		fmt.Fprintln(os.Stderr, "The --config flag is required")
+		fs.Usage()
+		return exitUsage, false
+	}
+	level, err := pkg.ParseLogLevel(o.logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	pkg.SetLogLevel(level)
+	return exitOK, true
+}
+
+// load reads the config file and opens the state file, the --state flag overrides the state file in the config
+func (o *options) load() (*pkg.ConfigurationData, *pkg.StateStore, *pkg.JumpCloudAPI, int) {
+	conf, err := pkg.ReadConfigFile(o.configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading config file: ", err)
+		return nil, nil, nil, exitConfig
+	}
+	if o.statePath != "" {
+		conf.StateFile = o.statePath
+	}
+	state, err := pkg.OpenStateStore(conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading state file: ", err)
+		return nil, nil, nil, exitConfig
 	}
-	conf, err := pkg.ReadConfigFile(os.Args[1])
+	return conf, state, newJumpCloudAPI(conf), exitOK
+}
+
+// openSink opens where events are sent, events are filtered and then redacted on the way if the config has filter
+// or redaction rules
+func (o *options) openSink(fs *flag.FlagSet, conf *pkg.ConfigurationData) (pkg.EventSink, int) {
+	filter, err := conf.EventFilter()
 	if err != nil {
-		fmt.Println("Error reading config file: ", err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error loading filters: ", err)
+		return nil, exitConfig
 	}
-	jcAPI := pkg.NewJumpCloudAPI(pkg.NewJumpCloudAPIOptions{
-		APIKey:  conf.APIKey,
-		BaseURL: conf.BaseURL,
-		OrgID:   conf.OrgID,
-	})
-	err = pkg.RunService(conf, jcAPI, os.Args[2])
+	redactor, err := conf.Redactor()
 	if err != nil {
-		fmt.Println("Error fetching events from JumpCloud API: ", err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error loading redaction: ", err)
+		return nil, exitConfig
+	}
+	sink, code := o.openOutputs(fs, conf)
+	if code != exitOK {
+		return nil, code
+	}
+	return pkg.NewFilteringSink(pkg.NewRedactingSink(sink, redactor), filter), exitOK
+}
+
+// openOutputs opens stdout for a dry run, the --output file if it is set, otherwise the outputs in the config
+func (o *options) openOutputs(fs *flag.FlagSet, conf *pkg.ConfigurationData) (pkg.EventSink, int) {
+	if o.dryRun {
+		return pkg.NewWriterSink(os.Stdout), exitOK
 	}
-	fmt.Println("Successfully ran JumpCloud event service")
-	return
+	if o.outputPath != "" {
+		sink, err := pkg.NewFileSink(o.outputPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening output file: ", err)
+			return nil, exitConfig
+		}
+		return sink, exitOK
+	}
+	if len(conf.Outputs) == 0 {
+		fmt.Fprintln(os.Stderr, "The --output flag is required unless outputs are set in the config")
+		fs.Usage()
+		return nil, exitUsage
+	}
+	sink, err := pkg.OpenOutputs(conf.Outputs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening outputs: ", err)
+		return nil, exitConfig
+	}
+	return sink, exitOK
+}
+
+func newJumpCloudAPI(conf *pkg.ConfigurationData) *pkg.JumpCloudAPI {
+	return pkg.NewJumpCloudAPI(conf.APIOptions())
+}
+
+// failureCode returns the exit code for a failed run, an API key JumpCloud rejects is reported as a connection
+// failure so it stands out from transient errors in ossec.log
+func failureCode(err error) int {
+	if errors.Is(err, pkg.ErrAuthFailed) {
+		return exitConnection
+	}
+	return exitFailure
+}
+
+func runCommand(args []string) int {
+	fs, o := newFlagSet("run", "Collect events since the last checkpoint once and exit.")
+	o.addStateFlag(fs)
+	o.addOutputFlag(fs)
+	o.addDryRunFlag(fs)
+	o.addTimeoutFlag(fs, 0)
+	if code, ok := o.parse(fs, args); !ok {
+		return code
+	}
+	conf, state, jcAPI, code := o.load()
+	if code != exitOK {
+		return code
+	}
+	sink, code := o.openSink(fs, conf)
+	if code != exitOK {
+		return code
+	}
+	defer sink.Close()
+	ctx, cancel := signalContext(o.timeout)
+	defer cancel()
+	var tracker pkg.TimeTracker = state
+	if o.dryRun {
+		tracker = pkg.ReadOnlyTimeTracker(state)
+	}
+	err := pkg.RunServiceToSinkContext(ctx, tracker, jcAPI, sink)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error fetching events from JumpCloud API: ", err)
+		return failureCode(err)
+	}
+	fmt.Fprintln(os.Stderr, "Successfully ran JumpCloud event service")
+	return exitOK
+}
+
+func daemonCommand(args []string) int {
+	fs, o := newFlagSet("daemon", "Collect events on the configured poll_interval until SIGTERM or SIGINT is received.")
+	o.addStateFlag(fs)
+	o.addOutputFlag(fs)
+	o.addDryRunFlag(fs)
+	if code, ok := o.parse(fs, args); !ok {
+		return code
+	}
+	conf, state, jcAPI, code := o.load()
+	if code != exitOK {
+		return code
+	}
+	sink, code := o.openSink(fs, conf)
+	if code != exitOK {
+		return code
+	}
+	defer sink.Close()
+	// Stop polling on SIGTERM or SIGINT, a run in progress stops querying and checkpoints the events it wrote
+	ctx, cancel := signalContext(0)
+	defer cancel()
+	var tracker pkg.TimeTracker = state
+	if o.dryRun {
+		tracker = pkg.ReadOnlyTimeTracker(state)
+	}
+	err := pkg.RunDaemon(ctx, tracker, jcAPI, sink, conf.DaemonOptions())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error running JumpCloud event daemon: ", err)
+		return exitFailure
+	}
+	fmt.Fprintln(os.Stderr, "JumpCloud event daemon stopped")
+	return exitOK
+}
+
+// parseTime accepts an RFC 3339 time or a date, which is taken as midnight UTC
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or a YYYY-MM-DD date", value)
+	}
+	return t, nil
+}
+
+func backfillCommand(args []string) int {
+	fs, o := newFlagSet("backfill", "Collect every event between --from and --to and send them to the outputs.  The checkpoint in the state\nfile is never read or changed so a backfill can run next to the live service.")
+	o.addOutputFlag(fs)
+	o.addDryRunFlag(fs)
+	o.addTimeoutFlag(fs, 0)
+	from := fs.String("from", "", "start of the window as an RFC 3339 time or YYYY-MM-DD date (required)")
+	to := fs.String("to", "", "end of the window as an RFC 3339 time or YYYY-MM-DD date, defaults to now")
+	chunk := fs.Duration("chunk", 24*time.Hour, "size of the window queried at a time")
+	pause := fs.Duration("pause", time.Second, "time to wait between queries to stay under the JumpCloud API rate limits")
+	if code, ok := o.parse(fs, args); !ok {
+		return code
+	}
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "The --from flag is required")
+		fs.Usage()
+		return exitUsage
+	}
+	options := pkg.BackfillOptions{To: time.Now(), Chunk: *chunk, Pause: *pause}
+	var err error
+	options.From, err = parseTime(*from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid --from: ", err)
+		return exitUsage
+	}
+	if *to != "" {
+		options.To, err = parseTime(*to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid --to: ", err)
+			return exitUsage
+		}
+	}
+	conf, err := pkg.ReadConfigFile(o.configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading config file: ", err)
+		return exitConfig
+	}
+	sink, code := o.openSink(fs, conf)
+	if code != exitOK {
+		return code
+	}
+	defer sink.Close()
+	jcAPI := newJumpCloudAPI(conf)
+	ctx, cancel := signalContext(o.timeout)
+	defer cancel()
+	err = pkg.RunBackfillToSinkContext(ctx, jcAPI, sink, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error backfilling events from JumpCloud API: ", err)
+		return failureCode(err)
+	}
+	fmt.Fprintln(os.Stderr, "Successfully backfilled JumpCloud events")
+	return exitOK
+}
+
+func validateConfigCommand(args []string) int {
+	fs, o := newFlagSet("validate-config", "Check the config file is valid and the state file can be read.")
+	o.addStateFlag(fs)
+	if code, ok := o.parse(fs, args); !ok {
+		return code
+	}
+	conf, _, _, code := o.load()
+	if code != exitOK {
+		return code
+	}
+	if conf.APIKey == "" {
+		fmt.Fprintln(os.Stderr, "Config file is missing api_key")
+		return exitConfig
+	}
+	if _, err := conf.Redactor(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading redaction: ", err)
+		return exitConfig
+	}
+	fmt.Printf("Config file %v is valid, state is kept in %v\n", o.configPath, conf.StatePath())
+	return exitOK
+}
+
+func testConnectionCommand(args []string) int {
+	fs, o := newFlagSet("test-connection", "Run a minimal query to check JumpCloud accepts the configured API key and org ID.")
+	o.addTimeoutFlag(fs, time.Minute)
+	if code, ok := o.parse(fs, args); !ok {
+		return code
+	}
+	_, _, jcAPI, code := o.load()
+	if code != exitOK {
+		return code
+	}
+	ctx, cancel := signalContext(o.timeout)
+	defer cancel()
+	err := jcAPI.TestConnectionContext(ctx)
+	switch {
+	case errors.Is(err, pkg.ErrAuthFailed):
+		fmt.Fprintln(os.Stderr, "JumpCloud rejected the API key or org ID: ", err)
+		return exitConnection
+	case errors.Is(err, pkg.ErrThrottled):
+		fmt.Fprintln(os.Stderr, "JumpCloud API rate limit exceeded, try again later: ", err)
+		return exitConnection
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error connecting to JumpCloud API: ", err)
+		return exitConnection
+	}
+	fmt.Println("Successfully connected to JumpCloud API")
+	return exitOK
+}
+
+func versionCommand(args []string) int {
+	fmt.Println(version)
+	return exitOK
 }
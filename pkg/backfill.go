@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jumpCloudRetention is how long JumpCloud keeps Directory Insights events
+const jumpCloudRetention = 90 * 24 * time.Hour
+
+// Defaults used by RunBackfill when BackfillOptions are left empty
+const (
+	defaultBackfillChunk = 24 * time.Hour
+	defaultBackfillPause = time.Second
+)
+
+// JumpCloudBackfillConnector can query a fixed historical window of a service
+type JumpCloudBackfillConnector interface {
+	Services() []string
+	// StreamServiceEventsBetween hands the events of a service between the given times to handle one at a time, oldest
+	// first.  It must stop and return the error if handle returns one, and the context error once ctx is done
+	StreamServiceEventsBetween(ctx context.Context, service string, startTime time.Time, endTime time.Time, handle func(JumpCloudEvent) error) error
+}
+
+// BackfillOptions selects the historical window RunBackfill collects
+type BackfillOptions struct {
+	From time.Time
+	To   time.Time
+	// Chunk is the size of the window queried at a time, defaults to 24 hours
+	Chunk time.Duration
+	// Pause is the time to wait between queries so a long backfill stays under the JumpCloud API rate limits,
+	// defaults to 1 second
+	Pause time.Duration
+}
+
+func (o BackfillOptions) validate() error {
+	if o.From.IsZero() || o.To.IsZero() {
+		return fmt.Errorf("backfill needs both a start and an end time")
+	}
+	if !o.From.Before(o.To) {
+		return fmt.Errorf("backfill start time %v must be before the end time %v", o.From, o.To)
+	}
+	if o.Chunk < 0 || o.Pause < 0 {
+		return fmt.Errorf("backfill chunk and pause can not be negative")
+	}
+	return nil
+}
+
+// RunBackfill collects every event between the from and to times and appends them to the log file.  It never reads
+// or changes the checkpoints so it can run next to the live service
+func RunBackfill(j JumpCloudBackfillConnector, pathToLogFile string, options BackfillOptions) error {
+	return RunBackfillContext(context.Background(), j, pathToLogFile, options)
+}
+
+// RunBackfillContext is RunBackfill with a context that stops the backfill between queries and events
+func RunBackfillContext(ctx context.Context, j JumpCloudBackfillConnector, pathToLogFile string, options BackfillOptions) error {
+	sink, err := NewFileSink(pathToLogFile)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	return RunBackfillToSinkContext(ctx, j, sink, options)
+}
+
+// RunBackfillToWriter collects every event between the from and to times and writes them to w.  The window is walked
+// oldest first in chunks, if a chunk fails the error names the time the backfill can be restarted from
+func RunBackfillToWriter(j JumpCloudBackfillConnector, w io.Writer, options BackfillOptions) error {
+	return RunBackfillToWriterContext(context.Background(), j, w, options)
+}
+
+// RunBackfillToWriterContext is RunBackfillToWriter with a context that stops the backfill between queries and events
+func RunBackfillToWriterContext(ctx context.Context, j JumpCloudBackfillConnector, w io.Writer, options BackfillOptions) error {
+	return RunBackfillToSinkContext(ctx, j, NewWriterSink(w), options)
+}
+
+// RunBackfillToSinkContext collects every event between the from and to times and sends them to sink, such as the
+// filtering and redacting sink the service writes to.  Every chunk is flushed before the next is queried so a
+// restart from the time named in an error does not lose events
+func RunBackfillToSinkContext(ctx context.Context, j JumpCloudBackfillConnector, sink EventSink, options BackfillOptions) error {
+	err := options.validate()
+	if err != nil {
+		return err
+	}
+	if options.Chunk == 0 {
+		options.Chunk = defaultBackfillChunk
+	}
+	if options.Pause == 0 {
+		options.Pause = defaultBackfillPause
+	}
+	if options.From.Before(time.Now().Add(-jumpCloudRetention)) {
+		logWarnf("Backfill starts before the %v days JumpCloud retains events, the oldest part of the window will be empty", int(jumpCloudRetention.Hours()/24))
+	}
+	queries := 0
+	for _, service := range j.Services() {
+		// Events on a chunk boundary can be returned by both chunks, remember the previous chunk to skip them
+		var previous map[string]bool
+		total := 0
+		for chunkStart := options.From; chunkStart.Before(options.To); chunkStart = chunkStart.Add(options.Chunk) {
+			chunkEnd := chunkStart.Add(options.Chunk)
+			if chunkEnd.After(options.To) {
+				chunkEnd = options.To
+			}
+			if queries > 0 {
+				err = sleepContext(ctx, options.Pause)
+				if err != nil {
+					return fmt.Errorf("backfill of %v events stopped, restart from %v: %w", service, chunkStart.UTC().Format(time.RFC3339), err)
+				}
+			}
+			queries++
+			current := map[string]bool{}
+			written := 0
+			err = j.StreamServiceEventsBetween(ctx, service, chunkStart, chunkEnd, func(x JumpCloudEvent) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if x.getID() != "" {
+					if previous[x.getID()] || current[x.getID()] {
+						return nil
+					}
+					current[x.getID()] = true
+				}
+				err := writeDocument(sink, x.wazuhDocument())
+				if errors.Is(err, ErrEventFiltered) {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("error writing backfilled events: %w", err)
+				}
+				written++
+				return nil
+			})
+			// Flush even after a failed or stopped chunk so the events before it are kept
+			flushErr := sink.Flush()
+			if ctx.Err() != nil {
+				return fmt.Errorf("backfill of %v events stopped, restart from %v: %w", service, chunkStart.UTC().Format(time.RFC3339), ctx.Err())
+			}
+			if err == nil && flushErr != nil {
+				err = fmt.Errorf("events were not confirmed by every required output: %w", flushErr)
+			}
+			if err != nil {
+				return fmt.Errorf("error backfilling %v events, restart from %v: %w", service, chunkStart.UTC().Format(time.RFC3339), err)
+			}
+			previous = current
+			total += written
+			logInfof("Backfilled %v %v events from %v to %v", written, service, chunkStart.UTC().Format(time.RFC3339), chunkEnd.UTC().Format(time.RFC3339))
+		}
+		logInfof("Backfilled %v %v events in total", total, service)
+	}
+	return nil
+}
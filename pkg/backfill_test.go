@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// windowConnector is a JumpCloudBackfillConnector that returns the events of a fixed list that fall inside each
+// window, including events exactly on the end time so chunk boundaries overlap
+type windowConnector struct {
+	events  map[string][]time.Time
+	windows []string
+}
+
+func (w *windowConnector) Services() []string {
+	return []string{"sso"}
+}
+
+func (w *windowConnector) StreamServiceEventsBetween(_ context.Context, service string, startTime time.Time, endTime time.Time, handle func(JumpCloudEvent) error) error {
+	w.windows = append(w.windows, startTime.Format(time.RFC3339)+"/"+endTime.Format(time.RFC3339))
+	var elements []string
+	for id, timestamps := range w.events {
+		for _, ts := range timestamps {
+			if !ts.Before(startTime) && !ts.After(endTime) {
+				elements = append(elements, fmt.Sprintf(`{"service":"%v","id":"%v","timestamp":"%v"}`, service, id, ts.Format(time.RFC3339)))
+			}
+		}
+	}
+	_, err := decodeJumpCloudEventStream(strings.NewReader("["+strings.Join(elements, ",")+"]"), false, handle)
+	return err
+}
+
+func TestRunBackfillToWriter(t *testing.T) {
+	from := time.Now().Add(-72 * time.Hour).Truncate(time.Hour)
+	connector := &windowConnector{events: map[string][]time.Time{
+		"first":    {from.Add(time.Hour)},
+		"boundary": {from.Add(24 * time.Hour)},
+		"last":     {from.Add(60 * time.Hour)},
+		"outside":  {from.Add(-time.Hour)},
+	}}
+	var out bytes.Buffer
+	err := RunBackfillToWriter(connector, &out, BackfillOptions{
+		From:  from,
+		To:    from.Add(72 * time.Hour),
+		Chunk: 24 * time.Hour,
+		Pause: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("RunBackfillToWriter() error = %v", err)
+	}
+	if len(connector.windows) != 3 {
+		t.Errorf("RunBackfillToWriter() queried %v windows, want 3: %v", len(connector.windows), connector.windows)
+	}
+	for id, want := range map[string]int{"first": 1, "boundary": 1, "last": 1, "outside": 0} {
+		if got := strings.Count(out.String(), `"id":"`+id+`"`); got != want {
+			t.Errorf("RunBackfillToWriter() wrote %v %v times, want %v", id, got, want)
+		}
+	}
+}
+
+func TestRunBackfillToWriterInvalidWindow(t *testing.T) {
+	now := time.Now()
+	err := RunBackfillToWriter(&windowConnector{}, &bytes.Buffer{}, BackfillOptions{From: now, To: now.Add(-time.Hour)})
+	if err == nil {
+		t.Errorf("RunBackfillToWriter() expected an error when the start is after the end")
+	}
+}
+
+func TestRunBackfillToSink(t *testing.T) {
+	from := time.Now().Add(-48 * time.Hour).Truncate(time.Hour)
+	connector := &windowConnector{events: map[string][]time.Time{
+		"first": {from.Add(time.Hour)},
+		"last":  {from.Add(30 * time.Hour)},
+	}}
+	tests := []struct {
+		name        string
+		flushErr    error
+		wantFlushes int
+		wantErr     bool
+	}{
+		{name: "TestRunBackfillToSinkFlushesEveryChunk", wantFlushes: 2},
+		{name: "TestRunBackfillToSinkUnconfirmed", flushErr: errors.New("disk full"), wantFlushes: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{flushErr: tt.flushErr}
+			err := RunBackfillToSinkContext(context.Background(), connector, newFormatSink(sink, formatECS), BackfillOptions{
+				From:  from,
+				To:    from.Add(48 * time.Hour),
+				Pause: time.Nanosecond,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunBackfillToSinkContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(fmt.Sprint(err), "restart from "+from.UTC().Format(time.RFC3339)) {
+				t.Errorf("RunBackfillToSinkContext() error = %v, want it to name the chunk to restart from", err)
+			}
+			if sink.flushes != tt.wantFlushes {
+				t.Errorf("RunBackfillToSinkContext() flushed %v times, want %v", sink.flushes, tt.wantFlushes)
+			}
+			// Events go through the output's format like they do for the service
+			if len(sink.events) == 0 || !strings.Contains(sink.events[0], `"ecs":{"version"`) {
+				t.Errorf("RunBackfillToSinkContext() events = %v, want them in ECS", sink.events)
+			}
+		})
+	}
+}
+
+func TestRunBackfillToSinkFiltersAndRedacts(t *testing.T) {
+	from := time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+	connector := &windowConnector{events: map[string][]time.Time{"first": {from.Add(time.Hour)}, "second": {from.Add(2 * time.Hour)}}}
+	redactor, err := NewRedactor(RedactionConfig{Rules: []RedactionRule{{Fields: []string{"timestamp"}, Action: RedactDrop}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sink := NewRedactingSink(NewWriterSink(&out), redactor)
+	err = RunBackfillToSinkContext(context.Background(), connector, sink, BackfillOptions{From: from, To: from.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("RunBackfillToSinkContext() error = %v", err)
+	}
+	if !strings.Contains(out.String(), `"id":"first"`) || strings.Contains(out.String(), `"timestamp"`) {
+		t.Errorf("RunBackfillToSinkContext() got = %v, want the events without their timestamp", out.String())
+	}
+
+	// Dropped events are not an error and the filter reports them when each chunk is flushed
+	filter, err := NewEventFilter([]FilterRule{{Name: "sso", Action: FilterExclude, Services: []string{"sso"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = RunBackfillToSinkContext(context.Background(), connector, NewFilteringSink(sink, filter), BackfillOptions{From: from, To: from.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("RunBackfillToSinkContext() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("RunBackfillToSinkContext() got = %v, want every event dropped", out.String())
+	}
+	if got := filter.Dropped()["sso"]; got != 2 {
+		t.Errorf("RunBackfillToSinkContext() dropped %v events, want 2", got)
+	}
+	if filter.dropped[0] != 0 {
+		t.Errorf("RunBackfillToSinkContext() did not report the dropped events on flush")
+	}
+}
@@ -2,16 +2,80 @@ package pkg
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 type ConfigurationData struct {
-	APIKey  string     `json:"api_key"`
-	BaseURL string     `json:"base_url"`
-	OrgID   string     `json:"org_id"`
-	Last    *time.Time `json:"last"`
-	path    string     `json:"-"`
+	APIKey    string `json:"api_key"`
+	BaseURL   string `json:"base_url"`
+	OrgID     string `json:"org_id"`
+	RawEvents bool   `json:"raw_events,omitempty"`
+	// Services selects which JumpCloud services to collect, when empty all services are collected with one query
+	Services []ServiceConfig `json:"services,omitempty"`
+	// OverlapWindow is how far before each checkpoint events are queried again to catch late arrivals, defaults to
+	// 10 minutes.  Events already emitted in the window are skipped using their JumpCloud ID
+	OverlapWindow *Duration `json:"overlap_window,omitempty"`
+	// ConnectTimeout and ReadTimeout limit how long to wait on the JumpCloud API
+	ConnectTimeout *Duration `json:"connect_timeout,omitempty"`
+	ReadTimeout    *Duration `json:"read_timeout,omitempty"`
+	// MaxRetries is how many times a throttled or failed query is sent again, defaults to 5
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// PollInterval, PollJitter and MaxBackoff control how often events are collected in daemon mode
+	PollInterval *Duration `json:"poll_interval,omitempty"`
+	PollJitter   *Duration `json:"poll_jitter,omitempty"`
+	MaxBackoff   *Duration `json:"max_backoff,omitempty"`
+	// Outputs are the destinations events are sent to, every event goes to all of them.  The --output flag replaces
+	// them with a single file
+	Outputs []OutputConfig `json:"outputs,omitempty"`
+	// Filters keep or drop events before they reach any output, the first rule that matches an event decides
+	Filters []FilterRule `json:"filters,omitempty"`
+	// Redaction drops, masks or pseudonymizes fields of every event before it reaches any output
+	Redaction *RedactionConfig `json:"redaction,omitempty"`
+	// StateFile is where checkpoints are kept, defaults to state.json in the same directory as the config file
+	StateFile string `json:"state_file,omitempty"`
+	// Last is only read to carry the checkpoint of older versions, which stored it in the config file, into the
+	// state file
+	Last *time.Time `json:"last"`
+	path string     `json:"-"`
+}
+
+// defaultOverlapWindow is used when no overlap window is configured
+const defaultOverlapWindow = 10 * time.Minute
+
+// Duration is a time.Duration that is read from and written to JSON as a string such as "10m"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"10m\": %v", err)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// ServiceConfig configures collection of a single JumpCloud service
+type ServiceConfig struct {
+	Name string `json:"name"`
+	// Enabled defaults to true so a service can be listed without the field
+	Enabled *bool `json:"enabled,omitempty"`
+	// Limit is the number of events requested per page, defaults to the API maximum of 10000
+	Limit int `json:"limit,omitempty"`
+}
+
+// IsEnabled returns true unless the service was explicitly disabled
+func (s ServiceConfig) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
 }
 
 func ReadConfigFile(path string) (*ConfigurationData, error) {
@@ -24,23 +88,144 @@ func ReadConfigFile(path string) (*ConfigurationData, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = config.validate()
+	if err != nil {
+		return nil, err
+	}
 	config.path = path
 	return &config, nil
 }
 
-func (c *ConfigurationData) UpdateLast(newTime time.Time) error {
-	c.Last = &newTime
-	b, err := json.Marshal(c)
+// Overlap returns how far before a checkpoint events are queried again
+func (c *ConfigurationData) Overlap() time.Duration {
+	if c.OverlapWindow == nil {
+		return defaultOverlapWindow
+	}
+	return c.OverlapWindow.Duration
+}
+
+// DaemonOptions returns the polling options for daemon mode, unset options fall back to the daemon defaults
+func (c *ConfigurationData) DaemonOptions() DaemonOptions {
+	options := DaemonOptions{}
+	if c.PollInterval != nil {
+		options.Interval = c.PollInterval.Duration
+	}
+	if c.PollJitter != nil {
+		options.Jitter = c.PollJitter.Duration
+		// A jitter of 0 in the config turns it off rather than asking for the default
+		if options.Jitter == 0 {
+			options.Jitter = -1
+		}
+	}
+	if c.MaxBackoff != nil {
+		options.MaxBackoff = c.MaxBackoff.Duration
+	}
+	return options
+}
+
+// APIOptions returns the options for connecting to the JumpCloud API
+func (c *ConfigurationData) APIOptions() NewJumpCloudAPIOptions {
+	options := NewJumpCloudAPIOptions{
+		APIKey:    c.APIKey,
+		BaseURL:   c.BaseURL,
+		OrgID:     c.OrgID,
+		Services:  c.ServiceQueries(),
+		RawEvents: c.RawEvents,
+	}
+	if c.ConnectTimeout != nil {
+		options.ConnectTimeout = c.ConnectTimeout.Duration
+	}
+	if c.ReadTimeout != nil {
+		options.ReadTimeout = c.ReadTimeout.Duration
+	}
+	if c.MaxRetries != nil {
+		options.MaxRetries = *c.MaxRetries
+		// Zero means the default to NewJumpCloudAPI, an explicit zero in the config means no retries
+		if options.MaxRetries == 0 {
+			options.MaxRetries = -1
+		}
+	}
+	return options
+}
+
+// EventFilter returns the filter for the configured filter rules, or nil if every event is kept
+func (c *ConfigurationData) EventFilter() (*EventFilter, error) {
+	return NewEventFilter(c.Filters)
+}
+
+// Redactor returns the redactor for the configured redaction, or nil if events are not redacted
+func (c *ConfigurationData) Redactor() (*Redactor, error) {
+	if c.Redaction == nil {
+		return nil, nil
+	}
+	return NewRedactor(*c.Redaction)
+}
+
+// StatePath returns the path of the state file, by default state.json next to the config file
+func (c *ConfigurationData) StatePath() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return filepath.Join(filepath.Dir(c.path), "state.json")
+}
+
+// validate makes sure the configuration can be turned into valid queries
+func (c *ConfigurationData) validate() error {
+	seen := map[string]bool{}
+	for _, x := range c.Services {
+		if x.Name == "" {
+			return fmt.Errorf("service entry is missing a name")
+		}
+		if seen[x.Name] {
+			return fmt.Errorf("service %v is listed more than once", x.Name)
+		}
+		seen[x.Name] = true
+		if x.Limit < 0 || x.Limit > insightsPageLimit {
+			return fmt.Errorf("service %v limit must be between 1 and %v", x.Name, insightsPageLimit)
+		}
+	}
+	if c.OverlapWindow != nil && c.OverlapWindow.Duration < 0 {
+		return fmt.Errorf("overlap_window can not be negative")
+	}
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return fmt.Errorf("max_retries can not be negative")
+	}
+	if c.PollInterval != nil && c.PollInterval.Duration < time.Second {
+		return fmt.Errorf("poll_interval must be at least 1s")
+	}
+	if c.PollJitter != nil && c.PollJitter.Duration < 0 {
+		return fmt.Errorf("poll_jitter can not be negative")
+	}
+	for _, x := range c.Outputs {
+		err := x.validate()
+		if err != nil {
+			return err
+		}
+	}
+	err := validateFilterRules(c.Filters)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(c.path, b, 0644)
-	return err
+	if c.Redaction != nil {
+		err := c.Redaction.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.Services) > 0 && len(c.ServiceQueries()) == 0 {
+		return fmt.Errorf("every configured service is disabled")
+	}
+	return nil
 }
 
-func (c *ConfigurationData) GetLastTime() time.Time {
-	if c.Last == nil {
-		return time.Now().Add(-time.Hour * 1)
+// ServiceQueries returns the queries for every enabled service
+func (c *ConfigurationData) ServiceQueries() []ServiceQuery {
+	var queries []ServiceQuery
+	for _, x := range c.Services {
+		if !x.IsEnabled() {
+			continue
+		}
+		queries = append(queries, ServiceQuery{Service: x.Name, Limit: x.Limit})
 	}
-	return *c.Last
+	return queries
 }
@@ -25,6 +25,22 @@ func TestReadConfigFile(t *testing.T) {
 				path:    "../test_data/example_config.json",
 			},
 		},
+		{
+			name: "TestReadConfigFileBadServiceLimit",
+			args: args{
+				path: "../test_data/bad_services_config.json",
+			},
+			want:    ConfigurationData{},
+			wantErr: true,
+		},
+		{
+			name: "TestReadConfigFileBadOutput",
+			args: args{
+				path: "../test_data/bad_outputs_config.json",
+			},
+			want:    ConfigurationData{},
+			wantErr: true,
+		},
 		{
 			name: "TestReadConfigFileBadPath",
 			args: args{
@@ -49,3 +65,19 @@ func TestReadConfigFile(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigurationData_ServiceQueries(t *testing.T) {
+	conf, err := ReadConfigFile("../test_data/services_config.json")
+	if err != nil {
+		t.Fatalf("ReadConfigFile() error = %v", err)
+	}
+	got := conf.ServiceQueries()
+	want := []ServiceQuery{
+		{Service: "directory"},
+		{Service: "sso"},
+		{Service: "systems", Limit: 2500},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceQueries() got = %v, want %v", got, want)
+	}
+}
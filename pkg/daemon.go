@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Defaults used by RunDaemon when DaemonOptions are left empty, a 5 minute interval keeps a single tenant well
+// under the JumpCloud API rate limits
+const (
+	defaultPollInterval = 5 * time.Minute
+	defaultPollJitter   = 30 * time.Second
+	defaultMaxBackoff   = time.Hour
+)
+
+// DaemonOptions configures how often RunDaemon collects events
+type DaemonOptions struct {
+	// Interval is the time between runs, defaults to 5 minutes
+	Interval time.Duration
+	// Jitter is the most random time added to each wait so several managers do not poll in lockstep, defaults to
+	// 30 seconds.  A negative jitter turns it off
+	Jitter time.Duration
+	// MaxBackoff caps how long to wait after repeated failures, defaults to 1 hour
+	MaxBackoff time.Duration
+}
+
+// withDefaults fills in any option that was not set
+func (o DaemonOptions) withDefaults() DaemonOptions {
+	if o.Interval <= 0 {
+		o.Interval = defaultPollInterval
+	}
+	if o.Jitter == 0 {
+		o.Jitter = defaultPollJitter
+	}
+	if o.Jitter < 0 {
+		o.Jitter = 0
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.MaxBackoff < o.Interval {
+		o.MaxBackoff = o.Interval
+	}
+	return o
+}
+
+// RunDaemon runs the service on an interval until ctx is done.  Cancelling ctx aborts queries that are in progress,
+// the events already written by the current run are checkpointed before RunDaemon returns.  After a failed run the
+// wait is doubled for every consecutive failure, up to the maximum backoff
+func RunDaemon(ctx context.Context, timeTracker TimeTracker, j JumpCloudConnector, sink EventSink, options DaemonOptions) error {
+	options = options.withDefaults()
+	failures := 0
+	for {
+		err := RunServiceToSinkContext(ctx, timeTracker, j, sink)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			failures++
+			logErrorf("Error fetching events from JumpCloud API (%v consecutive failures): %v", failures, err)
+		} else {
+			failures = 0
+		}
+		delay := nextDaemonDelay(options, failures, rand.Int63n)
+		logDebugf("Next run in %v", delay)
+		if sleepContext(ctx, delay) != nil {
+			return nil
+		}
+	}
+}
+
+// nextDaemonDelay returns how long to wait before the next run, randInt63n is rand.Int63n and is only a parameter
+// so tests can make the jitter predictable
+func nextDaemonDelay(options DaemonOptions, failures int, randInt63n func(int64) int64) time.Duration {
+	delay := options.Interval
+	for i := 0; i < failures && delay < options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > options.MaxBackoff {
+		delay = options.MaxBackoff
+	}
+	if options.Jitter > 0 {
+		delay += time.Duration(randInt63n(int64(options.Jitter)))
+	}
+	return delay
+}
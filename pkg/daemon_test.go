@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_nextDaemonDelay(t *testing.T) {
+	options := DaemonOptions{
+		Interval:   5 * time.Minute,
+		Jitter:     30 * time.Second,
+		MaxBackoff: time.Hour,
+	}
+	maxJitter := func(n int64) int64 { return n - 1 }
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "TestNextDaemonDelayHealthy", failures: 0, want: 5*time.Minute + 30*time.Second - 1},
+		{name: "TestNextDaemonDelayOneFailure", failures: 1, want: 10*time.Minute + 30*time.Second - 1},
+		{name: "TestNextDaemonDelayThreeFailures", failures: 3, want: 40*time.Minute + 30*time.Second - 1},
+		{name: "TestNextDaemonDelayCapped", failures: 50, want: time.Hour + 30*time.Second - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDaemonDelay(options, tt.failures, maxJitter); got != tt.want {
+				t.Errorf("nextDaemonDelay() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemonOptions_withDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    DaemonOptions
+		wantJitter time.Duration
+	}{
+		{name: "TestDaemonOptionsDefaultJitter", options: DaemonOptions{}, wantJitter: defaultPollJitter},
+		{name: "TestDaemonOptionsJitter", options: DaemonOptions{Jitter: time.Second}, wantJitter: time.Second},
+		{name: "TestDaemonOptionsNoJitter", options: DaemonOptions{Jitter: -1}, wantJitter: 0},
+		{name: "TestDaemonOptionsConfigNoJitter", options: (&ConfigurationData{PollJitter: &Duration{}}).DaemonOptions(), wantJitter: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.options.withDefaults()
+			if got.Jitter != tt.wantJitter {
+				t.Errorf("withDefaults() jitter = %v, want %v", got.Jitter, tt.wantJitter)
+			}
+		})
+	}
+}
+
+func TestRunDaemonStops(t *testing.T) {
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)}
+	connector := &serviceConnector{
+		services: []string{"sso"},
+		payloads: map[string]string{"sso": `[]`},
+	}
+	sink := &recordingSink{flushed: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunDaemon(ctx, tracker, connector, sink, DaemonOptions{Interval: time.Hour})
+	}()
+	// Wait for the first run before stopping so there is a run to check
+	select {
+	case <-sink.flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RunDaemon() did not run")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunDaemon() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RunDaemon() did not stop")
+	}
+	if _, ok := connector.started["sso"]; !ok {
+		t.Errorf("RunDaemon() did not run the service before stopping")
+	}
+}
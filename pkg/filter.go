@@ -0,0 +1,315 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Actions a filter rule can take on the events it matches
+const (
+	FilterInclude = "include"
+	FilterExclude = "exclude"
+)
+
+// FilterRule keeps or drops the events that match every condition it sets, a rule without conditions matches every
+// event
+type FilterRule struct {
+	// Name identifies the rule in the dropped counts, defaults to its position such as rule 2
+	Name string `json:"name,omitempty"`
+	// Action is include to keep the matching events or exclude to drop them
+	Action string `json:"action"`
+	// Services and EventTypes match the service and event_type of an event, event types can use * such as ldap_*
+	Services   []string `json:"services,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+	// Success matches the success of an event, SSO events report it as sso_token_success
+	Success *bool `json:"success,omitempty"`
+	// Usernames and UsernameRegex match the user, email or initiated_by username of an event
+	Usernames     []string `json:"usernames,omitempty"`
+	UsernameRegex string   `json:"username_regex,omitempty"`
+	// ClientIPs match the client_ip of an event against addresses or CIDR ranges such as 10.0.0.0/8
+	ClientIPs []string `json:"client_ips,omitempty"`
+	// Applications match the name or display label of the SSO application, ignoring case
+	Applications []string `json:"applications,omitempty"`
+}
+
+// validate checks the rule's action, patterns and ranges
+func (x FilterRule) validate() error {
+	switch x.Action {
+	case FilterInclude, FilterExclude:
+	default:
+		return fmt.Errorf("filter %v has unknown action %q, expected include or exclude", x.Name, x.Action)
+	}
+	for _, p := range x.EventTypes {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("filter %v has invalid event type pattern %q", x.Name, p)
+		}
+	}
+	if x.UsernameRegex != "" {
+		if _, err := regexp.Compile(x.UsernameRegex); err != nil {
+			return fmt.Errorf("filter %v has invalid username_regex: %w", x.Name, err)
+		}
+	}
+	for _, ip := range x.ClientIPs {
+		if _, err := parseIPRange(ip); err != nil {
+			return fmt.Errorf("filter %v: %w", x.Name, err)
+		}
+	}
+	return nil
+}
+
+// parseIPRange parses an address or CIDR range, an address is a range of one
+func parseIPRange(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IP address or CIDR range", s)
+	}
+	return network, nil
+}
+
+// validateFilterRules checks every rule and names the unnamed ones by their position
+func validateFilterRules(rules []FilterRule) error {
+	names := map[string]bool{}
+	for i := range rules {
+		if rules[i].Name == "" {
+			rules[i].Name = fmt.Sprintf("rule %v", i+1)
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("filter %v is listed more than once", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+		err := rules[i].validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compiledFilterRule is a rule with its pattern and ranges parsed
+type compiledFilterRule struct {
+	FilterRule
+	usernameRegex *regexp.Regexp
+	clientIPs     []*net.IPNet
+}
+
+// matches returns true if the event meets every condition of the rule
+func (x compiledFilterRule) matches(event eventDocument) bool {
+	if len(x.Services) > 0 && !containsString(x.Services, event.str("service"), false) {
+		return false
+	}
+	if len(x.EventTypes) > 0 && !matchesEventType(x.EventTypes, event.str("event_type")) {
+		return false
+	}
+	if x.Success != nil {
+		success, ok := event.bool("success")
+		if !ok {
+			success, ok = event.bool("sso_token_success")
+		}
+		if !ok || success != *x.Success {
+			return false
+		}
+	}
+	if len(x.Usernames) > 0 || x.usernameRegex != nil {
+		if !x.matchesUser(event) {
+			return false
+		}
+	}
+	if len(x.clientIPs) > 0 {
+		ip := net.ParseIP(event.str("client_ip"))
+		if ip == nil || !containsIP(x.clientIPs, ip) {
+			return false
+		}
+	}
+	if len(x.Applications) > 0 {
+		if !containsString(x.Applications, event.str("application.name"), true) &&
+			!containsString(x.Applications, event.str("application.display_label"), true) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesUser returns true if any of the users named by the event matches the rule
+func (x compiledFilterRule) matchesUser(event eventDocument) bool {
+	for _, field := range []string{"initiated_by.username", "username", "initiated_by.email"} {
+		user := event.str(field)
+		if user == "" {
+			continue
+		}
+		if containsString(x.Usernames, user, false) {
+			return true
+		}
+		if x.usernameRegex != nil && x.usernameRegex.MatchString(user) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string, ignoreCase bool) bool {
+	if s == "" {
+		return false
+	}
+	for _, x := range list {
+		if x == s || (ignoreCase && strings.EqualFold(x, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesEventType(patterns []string, eventType string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventFilter decides which events are sent to the outputs.  Rules are checked in order and the first that matches
+// an event keeps or drops it, events no rule matches are kept
+type EventFilter struct {
+	rules []compiledFilterRule
+	// dropped counts the events each rule dropped since the last report and total since the filter was created
+	dropped []int
+	total   []int
+}
+
+// NewEventFilter returns a filter for rules, or nil if there are none
+func NewEventFilter(rules []FilterRule) (*EventFilter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rules = append([]FilterRule(nil), rules...)
+	err := validateFilterRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	f := &EventFilter{dropped: make([]int, len(rules)), total: make([]int, len(rules))}
+	for _, x := range rules {
+		compiled := compiledFilterRule{FilterRule: x}
+		if x.UsernameRegex != "" {
+			compiled.usernameRegex = regexp.MustCompile(x.UsernameRegex)
+		}
+		for _, ip := range x.ClientIPs {
+			network, _ := parseIPRange(ip)
+			compiled.clientIPs = append(compiled.clientIPs, network)
+		}
+		f.rules = append(f.rules, compiled)
+	}
+	return f, nil
+}
+
+// Keep returns true if the event should be sent to the outputs and counts the event against the rule that drops it
+func (f *EventFilter) Keep(payload []byte) (bool, error) {
+	event, err := decodeEventDocument(payload)
+	if err != nil {
+		return false, err
+	}
+	return f.keep(event), nil
+}
+
+// keep is Keep for a decoded event
+func (f *EventFilter) keep(event eventDocument) bool {
+	for i, x := range f.rules {
+		if !x.matches(event) {
+			continue
+		}
+		if x.Action == FilterExclude {
+			f.dropped[i]++
+			f.total[i]++
+			return false
+		}
+		return true
+	}
+	return true
+}
+
+// Dropped returns how many events each rule has dropped since the filter was created, keyed by rule name
+func (f *EventFilter) Dropped() map[string]int {
+	dropped := map[string]int{}
+	for i, x := range f.rules {
+		if x.Action == FilterExclude {
+			dropped[x.Name] = f.total[i]
+		}
+	}
+	return dropped
+}
+
+// report logs how many events each rule dropped since the last report
+func (f *EventFilter) report() {
+	for i, x := range f.rules {
+		if f.dropped[i] > 0 {
+			logInfof("Filter %v dropped %v events, %v since start", x.Name, f.dropped[i], f.total[i])
+		}
+		f.dropped[i] = 0
+	}
+}
+
+// ErrEventFiltered is returned by a filtering sink for an event a filter dropped.  The event was accepted and is
+// checkpointed like a written one, it only tells callers that it was not sent on to the outputs
+var ErrEventFiltered = errors.New("event was dropped by a filter")
+
+// filteringSink only writes the events a filter keeps to another sink
+type filteringSink struct {
+	filter *EventFilter
+	sink   EventSink
+}
+
+// NewFilteringSink returns a sink that writes the events filter keeps to sink, or sink itself if filter is nil.
+// Dropped events return ErrEventFiltered, they are checkpointed like written ones and not collected again
+func NewFilteringSink(sink EventSink, filter *EventFilter) EventSink {
+	if filter == nil {
+		return sink
+	}
+	return &filteringSink{filter: filter, sink: sink}
+}
+
+func (s *filteringSink) WriteEvent(payload []byte) error {
+	keep, err := s.filter.Keep(payload)
+	if err != nil {
+		return err
+	}
+	if !keep {
+		return ErrEventFiltered
+	}
+	return s.sink.WriteEvent(payload)
+}
+
+func (s *filteringSink) writeDocument(event eventDocument) error {
+	if !s.filter.keep(event) {
+		return ErrEventFiltered
+	}
+	return writeDocument(s.sink, event)
+}
+
+// Flush reports the events dropped since the last Flush and flushes the other sink
+func (s *filteringSink) Flush() error {
+	s.filter.report()
+	return s.sink.Flush()
+}
+
+func (s *filteringSink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterRule_matches(t *testing.T) {
+	yes, no := true, false
+	event := `{"service":"sso","event_type":"sso_auth","sso_token_success":true,"client_ip":"10.1.2.3",` +
+		`"initiated_by":{"username":"svc-backup","email":"backup@example.com"},"application":{"name":"slack","display_label":"Slack"}}`
+	tests := []struct {
+		name string
+		rule FilterRule
+		want bool
+	}{
+		{name: "TestFilterMatchesEverything", rule: FilterRule{}, want: true},
+		{name: "TestFilterService", rule: FilterRule{Services: []string{"ldap", "sso"}}, want: true},
+		{name: "TestFilterOtherService", rule: FilterRule{Services: []string{"ldap"}}, want: false},
+		{name: "TestFilterEventTypePattern", rule: FilterRule{EventTypes: []string{"sso_*"}}, want: true},
+		{name: "TestFilterOtherEventType", rule: FilterRule{EventTypes: []string{"ldap_search"}}, want: false},
+		{name: "TestFilterSSOTokenSuccess", rule: FilterRule{Success: &yes}, want: true},
+		{name: "TestFilterFailure", rule: FilterRule{Success: &no}, want: false},
+		{name: "TestFilterUsername", rule: FilterRule{Usernames: []string{"svc-backup"}}, want: true},
+		{name: "TestFilterUsernameEmail", rule: FilterRule{Usernames: []string{"backup@example.com"}}, want: true},
+		{name: "TestFilterUsernameRegex", rule: FilterRule{UsernameRegex: "^svc-"}, want: true},
+		{name: "TestFilterUsernameRegexNoMatch", rule: FilterRule{UsernameRegex: "^admin-"}, want: false},
+		{name: "TestFilterCIDR", rule: FilterRule{ClientIPs: []string{"192.168.0.0/16", "10.0.0.0/8"}}, want: true},
+		{name: "TestFilterSingleIP", rule: FilterRule{ClientIPs: []string{"10.1.2.4"}}, want: false},
+		{name: "TestFilterApplicationIgnoresCase", rule: FilterRule{Applications: []string{"SLACK"}}, want: true},
+		{name: "TestFilterEveryCondition", rule: FilterRule{Services: []string{"sso"}, Success: &yes, Applications: []string{"zoom"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = FilterExclude
+			f, err := NewEventFilter([]FilterRule{tt.rule})
+			if err != nil {
+				t.Fatalf("NewEventFilter() error = %v", err)
+			}
+			doc, err := decodeEventDocument([]byte(event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.rules[0].matches(doc); got != tt.want {
+				t.Errorf("matches() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventFilter_Keep(t *testing.T) {
+	f, err := NewEventFilter([]FilterRule{
+		{Name: "keep failures", Action: FilterInclude, Success: boolPointer(false)},
+		{Name: "ldap searches", Action: FilterExclude, Services: []string{"ldap"}, EventTypes: []string{"ldap_search"}},
+		{Action: FilterExclude, Services: []string{"systems"}},
+	})
+	if err != nil {
+		t.Fatalf("NewEventFilter() error = %v", err)
+	}
+	events := []struct {
+		payload string
+		want    bool
+	}{
+		{`{"service":"ldap","event_type":"ldap_search","success":true}`, false},
+		{`{"service":"ldap","event_type":"ldap_search","success":true}`, false},
+		{`{"service":"ldap","event_type":"ldap_search","success":false}`, true},
+		{`{"service":"ldap","event_type":"ldap_bind","success":true}`, true},
+		{`{"service":"systems","event_type":"login_attempt","success":true}`, false},
+		{`{"service":"directory","event_type":"user_create","success":true}`, true},
+	}
+	for _, e := range events {
+		got, err := f.Keep([]byte(e.payload))
+		if err != nil {
+			t.Fatalf("Keep() error = %v", err)
+		}
+		if got != e.want {
+			t.Errorf("Keep() %v got = %v, want %v", e.payload, got, e.want)
+		}
+	}
+	want := map[string]int{"ldap searches": 2, "rule 3": 1}
+	got := f.Dropped()
+	if len(got) != len(want) || got["ldap searches"] != 2 || got["rule 3"] != 1 {
+		t.Errorf("Dropped() got = %v, want %v", got, want)
+	}
+	f.report()
+	if f.Dropped()["ldap searches"] != 2 {
+		t.Errorf("Dropped() was reset by report, it should count since the filter was created")
+	}
+}
+
+func TestEventFilterKeepsEventsWithoutSuccess(t *testing.T) {
+	f, err := NewEventFilter([]FilterRule{{Name: "failures", Action: FilterExclude, Success: boolPointer(false)}})
+	if err != nil {
+		t.Fatalf("NewEventFilter() error = %v", err)
+	}
+	raw := `[{"service":"directory","event_type":"user_update","id":"dir-4","timestamp":"2023-02-15T10:00:00Z"},` +
+		`{"service":"mdm","event_type":"mdm_command_result","id":"mdm-2","timestamp":"2023-02-15T10:00:06Z"}]`
+	// Decoded into the typed events first, so a missing success is not read as false
+	_, err = decodeJumpCloudEventStream(strings.NewReader(raw), false, func(x JumpCloudEvent) error {
+		payload, err := wazuhPayload(x)
+		if err != nil {
+			return err
+		}
+		keep, err := f.Keep(payload)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			t.Errorf("Keep() dropped %s, an event without success is not a failure", payload)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decodeJumpCloudEventStream() error = %v", err)
+	}
+}
+
+func boolPointer(b bool) *bool {
+	return &b
+}
+
+func TestFilterRule_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []FilterRule
+		wantErr bool
+	}{
+		{name: "TestFilterValid", rules: []FilterRule{{Action: FilterExclude, EventTypes: []string{"ldap_*"}, ClientIPs: []string{"10.0.0.0/8", "::1"}}}},
+		{name: "TestFilterUnknownAction", rules: []FilterRule{{Action: "drop"}}, wantErr: true},
+		{name: "TestFilterBadPattern", rules: []FilterRule{{Action: FilterExclude, EventTypes: []string{"ldap_["}}}, wantErr: true},
+		{name: "TestFilterBadRegex", rules: []FilterRule{{Action: FilterExclude, UsernameRegex: "("}}, wantErr: true},
+		{name: "TestFilterBadCIDR", rules: []FilterRule{{Action: FilterExclude, ClientIPs: []string{"10.0.0.0/33"}}}, wantErr: true},
+		{name: "TestFilterDuplicateName", rules: []FilterRule{{Name: "a", Action: FilterExclude}, {Name: "a", Action: FilterInclude}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEventFilter(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEventFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunServiceFiltersEvents(t *testing.T) {
+	payload, err := os.ReadFile("../test_data/mixed_events.json")
+	if err != nil {
+		t.Fatalf("error reading test payload: %v", err)
+	}
+	filter, err := NewEventFilter([]FilterRule{{Name: "noise", Action: FilterExclude, Services: []string{"ldap", "systems"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)}
+	out := &recordingSink{}
+	var logs bytes.Buffer
+	logMu.Lock()
+	logOutput = &logs
+	logMu.Unlock()
+	defer func() {
+		logMu.Lock()
+		logOutput = os.Stderr
+		logMu.Unlock()
+	}()
+	err = RunServiceToSinkContext(context.Background(), tracker, &payloadConnector{payload: payload}, NewFilteringSink(out, filter))
+	if err != nil {
+		t.Fatalf("RunServiceToSinkContext() error = %v", err)
+	}
+	for _, e := range out.events {
+		if strings.Contains(e, `"id":"ldap-1"`) || strings.Contains(e, `"id":"sys-1"`) {
+			t.Errorf("RunServiceToSinkContext() wrote filtered event %v", e)
+		}
+	}
+	if len(out.events) != 11 || filter.Dropped()["noise"] != 2 {
+		t.Errorf("RunServiceToSinkContext() wrote %v events and dropped %v, want 11 and 2", len(out.events), filter.Dropped()["noise"])
+	}
+	// Dropped events are checkpointed like written ones so they are not collected again
+	if !tracker.SeenEvent(AllServices, "ldap-1") || !tracker.last.Equal(time.Date(2023, 2, 15, 10, 0, 12, 0, time.UTC)) {
+		t.Errorf("RunServiceToSinkContext() did not checkpoint the dropped events")
+	}
+	if !strings.Contains(logs.String(), "Wrote 11 new") {
+		t.Errorf("RunServiceToSinkContext() logged %q, want only the kept events counted as written", logs.String())
+	}
+}
@@ -0,0 +1,309 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Formats an output can send events in
+const (
+	// FormatWazuh is the JumpCloud event as JSON with jumpcloud_event_type added, the format the Wazuh rules expect
+	FormatWazuh = "wazuh"
+	// FormatECS maps events to the Elastic Common Schema
+	FormatECS = "ecs"
+	// FormatOCSF maps events to Open Cybersecurity Schema Framework classes
+	FormatOCSF = "ocsf"
+	// FormatCEF and FormatLEEF are the ArcSight and QRadar event formats
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+	// FormatFlat is the wazuh format with nested objects flattened into single level keys
+	FormatFlat = "flat"
+)
+
+// eventDocument is an event as the generic JSON object wazuhDocument produces, numbers are kept as
+// json.Number so they are written back exactly as JumpCloud sent them
+type eventDocument map[string]interface{}
+
+// eventFormatter turns an event into the payload an output sends, a single line without a trailing newline
+type eventFormatter func(event eventDocument) ([]byte, error)
+
+// validFormat returns an error if format is not one an output can send events in
+func validFormat(format string) error {
+	switch format {
+	case "", FormatWazuh, FormatECS, FormatOCSF, FormatCEF, FormatLEEF, FormatFlat:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected one of wazuh, ecs, ocsf, cef, leef or flat", format)
+}
+
+// formatSink formats every event before it is written to another sink
+type formatSink struct {
+	format eventFormatter
+	sink   EventSink
+}
+
+// newFormatSink returns a sink that formats every event with format and writes it to sink, or sink itself if format
+// is nil
+func newFormatSink(sink EventSink, format eventFormatter) EventSink {
+	if format == nil {
+		return sink
+	}
+	return &formatSink{format: format, sink: sink}
+}
+
+func (s *formatSink) WriteEvent(payload []byte) error {
+	event, err := decodeEventDocument(payload)
+	if err != nil {
+		return err
+	}
+	return s.writeDocument(event)
+}
+
+func (s *formatSink) writeDocument(event eventDocument) error {
+	b, err := s.format(event)
+	if err != nil {
+		return fmt.Errorf("error formatting event %v: %w", event.str("id"), err)
+	}
+	return s.sink.WriteEvent(b)
+}
+
+func (s *formatSink) Flush() error {
+	return s.sink.Flush()
+}
+
+func (s *formatSink) Close() error {
+	return s.sink.Close()
+}
+
+// decodeEventDocument decodes the JSON of a single event
+func decodeEventDocument(payload []byte) (eventDocument, error) {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	event := eventDocument{}
+	err := d.Decode(&event)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding event: %w", err)
+	}
+	return event, nil
+}
+
+// encode returns the event as a single line of JSON, the same bytes json.Marshal writes for it.  Every event is
+// encoded on its way to the outputs so the common values are written directly instead of through reflection
+func (e eventDocument) encode() ([]byte, error) {
+	buf := encodeBuffers.Get().(*[]byte)
+	defer encodeBuffers.Put(buf)
+	b, err := appendJSON((*buf)[:0], map[string]interface{}(e))
+	if err != nil {
+		return nil, err
+	}
+	*buf = b
+	// The buffer is reused by the next event, the payload gets a copy of exactly its size
+	return append([]byte(nil), b...), nil
+}
+
+// encodeBuffers holds the buffers events are encoded into
+var encodeBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 1024)
+	return &b
+}}
+
+// appendJSON appends the JSON of a decoded value to b, values decoding never produces are left to json.Marshal
+func appendJSON(b []byte, value interface{}) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendJSONString(b, v), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case json.Number:
+		if v == "" {
+			return append(b, '0'), nil
+		}
+		return append(b, v...), nil
+	case map[string]interface{}:
+		if v == nil {
+			return append(b, "null"...), nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = append(b, '{')
+		for i, key := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, key)
+			b = append(b, ':')
+			b, err = appendJSON(b, v[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case []interface{}:
+		if v == nil {
+			return append(b, "null"...), nil
+		}
+		b = append(b, '[')
+		for i, x := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b, err = appendJSON(b, x)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, encoded...), nil
+}
+
+// appendJSONString appends s as a JSON string escaped the way json.Marshal escapes it, including <, > and & so the
+// output is safe to embed in HTML, and invalid UTF-8 replaced by U+FFFD
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = utf8.AppendRune(b, utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 end lines in JavaScript
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// get returns the value at a dotted path such as initiated_by.username, or nil if any part of it is missing
+func (e eventDocument) get(path string) interface{} {
+	var v interface{} = map[string]interface{}(e)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// str returns the string at path, or an empty string if it is missing or not a string
+func (e eventDocument) str(path string) string {
+	s, _ := e.get(path).(string)
+	return s
+}
+
+// firstStr returns the first of the strings at paths that is not empty
+func (e eventDocument) firstStr(paths ...string) string {
+	for _, path := range paths {
+		if s := e.str(path); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// bool returns the boolean at path and whether it was set
+func (e eventDocument) bool(path string) (bool, bool) {
+	b, ok := e.get(path).(bool)
+	return b, ok
+}
+
+// number returns the number at path, or an empty number if it is missing or zero.  It is used for locations, where
+// zero is a placeholder rather than a real client location
+func (e eventDocument) number(path string) json.Number {
+	n, _ := e.get(path).(json.Number)
+	if f, err := n.Float64(); err != nil || f == 0 {
+		return ""
+	}
+	return n
+}
+
+// set stores value at a dotted path creating the objects on the way, empty strings, numbers, lists and objects are
+// skipped so formatted events only carry fields JumpCloud sent
+func set(m map[string]interface{}, path string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+	case json.Number:
+		if v == "" {
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			return
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return
+		}
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for the device fields of the cef and leef headers
+const (
+	defaultSIEMVendor  = "JumpCloud"
+	defaultSIEMProduct = "Directory Insights"
+	defaultSIEMVersion = "1.0"
+)
+
+// siemHeader is the device that is named in the header of every cef and leef event
+type siemHeader struct {
+	Vendor  string
+	Product string
+	Version string
+}
+
+// siemField is a key and value of a cef extension or leef attribute
+type siemField struct {
+	key   string
+	value string
+}
+
+// siemFields are the extensions or attributes of an event in the order they are written
+type siemFields []siemField
+
+// add adds a field unless its value is empty
+func (f *siemFields) add(key string, value string) {
+	if value != "" {
+		*f = append(*f, siemField{key: key, value: value})
+	}
+}
+
+// addLabelled adds a custom field and the label that names it unless its value is empty
+func (f *siemFields) addLabelled(key string, label string, value string) {
+	if value != "" {
+		*f = append(*f, siemField{key: key + "Label", value: label}, siemField{key: key, value: value})
+	}
+}
+
+// siemSeverity returns the severity of an event on the 1 to 10 scale both CEF and LEEF accept.  Failures are the most
+// severe and a success without MFA is more severe than one with it, events that do not record MFA or an outcome are low
+func siemSeverity(event eventDocument) int {
+	mfa, hasMFA := event.bool("mfa")
+	switch ecsOutcome(event) {
+	case "failure":
+		return 7
+	case "success":
+		if hasMFA && mfa {
+			return 1
+		}
+		if hasMFA {
+			return 5
+		}
+	}
+	return 3
+}
+
+// siemUser returns the user that performed an event, LDAP, RADIUS and system events name it at the top level
+func siemUser(event eventDocument) string {
+	return event.firstStr("initiated_by.username", "username", "initiated_by.email")
+}
+
+// siemUserAgent returns the client application of an event such as Chrome 110.0
+func siemUserAgent(event eventDocument) string {
+	return strings.TrimSpace(event.str("useragent.name") + " " + event.str("useragent.version"))
+}
+
+// formatCEF formats an event as ArcSight CEF.  The signature ID is the event_type and the key fields are standard
+// extensions, fields without one use the custom string extensions with a label
+func (h siemHeader) formatCEF(event eventDocument) ([]byte, error) {
+	eventType := event.str("event_type")
+	var b strings.Builder
+	b.WriteString("CEF:0|")
+	for _, field := range []string{h.Vendor, h.Product, h.Version, eventType, strings.ReplaceAll(eventType, "_", " ")} {
+		b.WriteString(escapeCEFHeader(field))
+		b.WriteByte('|')
+	}
+	b.WriteString(strconv.Itoa(siemSeverity(event)))
+	b.WriteByte('|')
+
+	var fields siemFields
+	if t, err := time.Parse(time.RFC3339Nano, event.str("timestamp")); err == nil {
+		fields.add("rt", strconv.FormatInt(t.UnixMilli(), 10))
+	}
+	fields.add("externalId", event.str("id"))
+	fields.add("cat", event.str("service"))
+	fields.add("act", eventType)
+	fields.add("outcome", cefOutcome(event))
+	fields.add("suser", siemUser(event))
+	fields.add("suid", event.str("initiated_by.id"))
+	fields.add("duser", event.str("resource.username"))
+	fields.add("duid", event.str("resource.id"))
+	fields.add("dhost", event.str("system.hostname"))
+	fields.add("requestClientApplication", siemUserAgent(event))
+	fields.add("msg", event.str("error_message"))
+	// src only holds IPv4 addresses, IPv6 clients go in the custom IPv6 extension
+	if ip := net.ParseIP(event.str("client_ip")); ip != nil && ip.To4() != nil {
+		fields.add("src", ip.String())
+	} else if ip != nil {
+		fields.addLabelled("c6a2", "Source IPv6 Address", ip.String())
+	}
+	fields.add("slat", event.number("geoip.latitude").String())
+	fields.add("slong", event.number("geoip.longitude").String())
+	fields.addLabelled("cs1", "organization", event.str("organization"))
+	fields.addLabelled("cs2", "application", event.firstStr("application.display_label", "application.name"))
+	fields.addLabelled("cs3", "country", event.str("geoip.country_code"))
+	if mfa, ok := event.bool("mfa"); ok {
+		fields.addLabelled("cs4", "mfa", strconv.FormatBool(mfa))
+	}
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.key + "=" + escapeCEFValue(f.value))
+	}
+	return []byte(b.String()), nil
+}
+
+// cefOutcome returns the outcome extension of an event, empty if the event does not record one
+func cefOutcome(event eventDocument) string {
+	outcome := ecsOutcome(event)
+	if outcome == "unknown" {
+		return ""
+	}
+	return outcome
+}
+
+// escapeCEFHeader escapes a CEF header field, a header can not span lines so line breaks become spaces
+func escapeCEFHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// escapeCEFValue escapes a CEF extension value
+func escapeCEFValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
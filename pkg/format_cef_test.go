@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func Test_formatCEF(t *testing.T) {
+	tests := []struct {
+		name   string
+		header siemHeader
+		event  string
+		want   string
+	}{
+		{
+			name:   "TestFormatCEFLogin",
+			header: siemHeader{Vendor: "JumpCloud", Product: "Directory Insights", Version: "1.0"},
+			event: `{"service":"directory","event_type":"user_login_attempt","success":false,"mfa":false,"id":"dir-1","organization":"org-1",` +
+				`"timestamp":"2023-02-15T10:00:00Z","client_ip":"203.0.113.9","error_message":"bad password\nlocked=true",` +
+				`"initiated_by":{"id":"u1","type":"user","username":"jdoe"},"geoip":{"country_code":"US","latitude":41.85,"longitude":-87.65},` +
+				`"useragent":{"name":"Chrome","version":"110.0.0"}}`,
+			want: `CEF:0|JumpCloud|Directory Insights|1.0|user_login_attempt|user login attempt|7|rt=1676455200000 externalId=dir-1 ` +
+				`cat=directory act=user_login_attempt outcome=failure suser=jdoe suid=u1 requestClientApplication=Chrome 110.0.0 ` +
+				`msg=bad password\nlocked\=true src=203.0.113.9 slat=41.85 slong=-87.65 cs1Label=organization cs1=org-1 ` +
+				`cs3Label=country cs3=US cs4Label=mfa cs4=false`,
+		},
+		{
+			name:   "TestFormatCEFHeaderEscaping",
+			header: siemHeader{Vendor: `Acme|Corp`, Product: `Back\slash`, Version: "2\n0"},
+			event:  `{"service":"admin","event_type":"admin_update","id":"admin-1","timestamp":"2023-02-15T10:00:05Z","client_ip":"2001:db8::1","initiated_by":{"type":"admin","email":"admin@example.com"}}`,
+			want: `CEF:0|Acme\|Corp|Back\\slash|2 0|admin_update|admin update|3|rt=1676455205000 externalId=admin-1 cat=admin ` +
+				`act=admin_update suser=admin@example.com c6a2Label=Source IPv6 Address c6a2=2001:db8::1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := decodeEventDocument([]byte(tt.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.header.formatCEF(event)
+			if err != nil {
+				t.Fatalf("formatCEF() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("formatCEF() got = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatCEFEventWithoutSuccess(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{
+			name:  "TestFormatCEFDirectoryWithoutSuccess",
+			event: `{"service":"directory","event_type":"user_update","id":"dir-4","timestamp":"2023-02-15T10:00:00Z","initiated_by":{"type":"admin","email":"admin@example.com"}}`,
+			want: `CEF:0|JumpCloud|Directory Insights|1.0|user_update|user update|3|rt=1676455200000 externalId=dir-4 cat=directory ` +
+				`act=user_update suser=admin@example.com`,
+		},
+		{
+			name:  "TestFormatCEFMDMCommandWithoutSuccess",
+			event: `{"service":"mdm","event_type":"mdm_command_result","id":"mdm-2","timestamp":"2023-02-15T10:00:06Z","command":{"type":"DeviceLock","status":"Acknowledged"}}`,
+			want:  `CEF:0|JumpCloud|Directory Insights|1.0|mdm_command_result|mdm command result|3|rt=1676455206000 externalId=mdm-2 cat=mdm act=mdm_command_result`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Decoded into the typed event first, so a missing success is not read as false
+			got := formatTestEvent(t, tt.event, OutputConfig{Format: FormatCEF})
+			if got != tt.want {
+				t.Errorf("formatCEF() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatLEEF(t *testing.T) {
+	tests := []struct {
+		name   string
+		header siemHeader
+		event  string
+		want   string
+	}{
+		{
+			name:   "TestFormatLEEFSSOLogin",
+			header: siemHeader{Vendor: "JumpCloud", Product: "Directory Insights", Version: "1.0"},
+			event: `{"service":"sso","event_type":"sso_auth","sso_token_success":true,"mfa":true,"id":"sso-1","timestamp":"2023-02-15T10:00:03.250Z",` +
+				`"client_ip":"203.0.113.9","initiated_by":{"id":"u1","username":"jdoe"},"application":{"display_label":"Slack\tWorkspace"}}`,
+			want: "LEEF:1.0|JumpCloud|Directory Insights|1.0|sso_auth|devTime=2023-02-15T10:00:03.250+0000\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\t" +
+				"cat=sso\tsev=1\tsrc=203.0.113.9\tusrName=jdoe\teventId=sso-1\toutcome=success\tmfa=true\tuserId=u1\tapplication=Slack\\tWorkspace",
+		},
+		{
+			name:   "TestFormatLEEFHeaderEscaping",
+			header: siemHeader{Vendor: "Acme|Corp", Product: "Directory\tInsights", Version: "1.0"},
+			event:  `{"service":"ldap","event_type":"ldap_bind","success":true,"id":"ldap-1","username":"jdoe","error_message":"a\\b"}`,
+			want:   "LEEF:1.0|Acme\\|Corp|Directory Insights|1.0|ldap_bind|cat=ldap\tsev=3\tusrName=jdoe\teventId=ldap-1\toutcome=success\treason=a\\\\b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := decodeEventDocument([]byte(tt.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.header.formatLEEF(event)
+			if err != nil {
+				t.Fatalf("formatLEEF() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("formatLEEF() got = %q, want %q", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_escapeCEFValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "TestEscapeCEFPlain", value: "jdoe", want: "jdoe"},
+		{name: "TestEscapeCEFEquals", value: "a=b", want: `a\=b`},
+		{name: "TestEscapeCEFBackslash", value: `DOMAIN\jdoe`, want: `DOMAIN\\jdoe`},
+		{name: "TestEscapeCEFNewlines", value: "one\r\ntwo\nthree\rfour", want: `one\ntwo\nthree\rfour`},
+		{name: "TestEscapeCEFPipeIsNotEscaped", value: "a|b", want: "a|b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeCEFValue(tt.value); got != tt.want {
+				t.Errorf("escapeCEFValue() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_siemSeverity(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  int
+	}{
+		{name: "TestSeverityFailure", event: `{"success":false,"mfa":true}`, want: 7},
+		{name: "TestSeveritySuccessWithMFA", event: `{"success":true,"mfa":true}`, want: 1},
+		{name: "TestSeveritySuccessWithoutMFA", event: `{"success":true,"mfa":false}`, want: 5},
+		{name: "TestSeveritySuccessNoMFAField", event: `{"success":true}`, want: 3},
+		{name: "TestSeverityUnknown", event: `{}`, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := decodeEventDocument([]byte(tt.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := siemSeverity(event); got != tt.want {
+				t.Errorf("siemSeverity() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputConfig_siemHeader(t *testing.T) {
+	got := OutputConfig{Type: OutputSyslog, Format: FormatCEF, Product: "SSO"}.siemHeader()
+	want := siemHeader{Vendor: defaultSIEMVendor, Product: "SSO", Version: defaultSIEMVersion}
+	if got != want {
+		t.Errorf("siemHeader() got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ecsVersion is the version of the Elastic Common Schema events are mapped to
+const ecsVersion = "8.11.0"
+
+// formatECS maps an event to the Elastic Common Schema.  Fields with an ECS equivalent are copied to it and the
+// original event is kept under jumpcloud so nothing JumpCloud sent is lost
+func formatECS(event eventDocument) ([]byte, error) {
+	service := event.str("service")
+	eventType := event.str("event_type")
+	category, types := ecsCategorization(service, eventType)
+	ecs := map[string]interface{}{}
+	set(ecs, "@timestamp", event.str("timestamp"))
+	set(ecs, "ecs.version", ecsVersion)
+	set(ecs, "event.kind", "event")
+	set(ecs, "event.module", "jumpcloud")
+	set(ecs, "event.dataset", "jumpcloud."+event.firstStr("jumpcloud_event_type", "service"))
+	set(ecs, "event.provider", service)
+	set(ecs, "event.id", event.str("id"))
+	set(ecs, "event.action", eventType)
+	set(ecs, "event.category", category)
+	set(ecs, "event.type", types)
+	set(ecs, "event.outcome", ecsOutcome(event))
+	set(ecs, "error.message", event.str("error_message"))
+	set(ecs, "organization.id", event.str("organization"))
+
+	set(ecs, "user.id", event.str("initiated_by.id"))
+	set(ecs, "user.name", event.firstStr("initiated_by.username", "username"))
+	set(ecs, "user.email", event.str("initiated_by.email"))
+	set(ecs, "user.target.id", event.str("resource.id"))
+	set(ecs, "user.target.name", event.str("resource.username"))
+
+	set(ecs, "source.ip", event.str("client_ip"))
+	set(ecs, "source.geo.country_iso_code", event.str("geoip.country_code"))
+	set(ecs, "source.geo.continent_code", event.str("geoip.continent_code"))
+	set(ecs, "source.geo.region_name", event.str("geoip.region_name"))
+	if country, region := event.str("geoip.country_code"), event.str("geoip.region_code"); country != "" && region != "" {
+		set(ecs, "source.geo.region_iso_code", country+"-"+region)
+	}
+	set(ecs, "source.geo.timezone", event.str("geoip.timezone"))
+	if lat, lon := event.number("geoip.latitude"), event.number("geoip.longitude"); lat != "" && lon != "" {
+		set(ecs, "source.geo.location", map[string]interface{}{"lat": lat, "lon": lon})
+	}
+
+	set(ecs, "user_agent.name", event.str("useragent.name"))
+	set(ecs, "user_agent.version", event.str("useragent.version"))
+	set(ecs, "user_agent.device.name", event.str("useragent.device"))
+	set(ecs, "user_agent.os.name", event.firstStr("useragent.os_name", "useragent.os"))
+	set(ecs, "user_agent.os.version", event.str("useragent.os_version"))
+	set(ecs, "user_agent.os.full", event.str("useragent.os_full"))
+
+	set(ecs, "host.id", event.str("system.id"))
+	set(ecs, "host.hostname", event.str("system.hostname"))
+	set(ecs, "host.name", event.str("system.displayName"))
+
+	set(ecs, "package.name", event.str("software.name"))
+	set(ecs, "package.version", event.str("software.version"))
+	set(ecs, "package.path", event.str("software.path"))
+
+	ecs["jumpcloud"] = map[string]interface{}(event)
+	return json.Marshal(ecs)
+}
+
+// ecsOutcome returns the event.outcome of an event, SSO events report success as sso_token_success
+func ecsOutcome(event eventDocument) string {
+	success, ok := event.bool("success")
+	if !ok {
+		success, ok = event.bool("sso_token_success")
+	}
+	switch {
+	case !ok:
+		return "unknown"
+	case success:
+		return "success"
+	}
+	return "failure"
+}
+
+// ecsCategorization returns the event.category and event.type of an event from its service and event_type
+func ecsCategorization(service string, eventType string) ([]string, []string) {
+	switch {
+	case isAuthentication(service, eventType):
+		return []string{"authentication"}, []string{"start"}
+	case service == "software":
+		if ecsChangeType(eventType) == "creation" {
+			return []string{"package"}, []string{"installation"}
+		}
+		return []string{"package"}, []string{ecsChangeType(eventType)}
+	case service == "systems" || service == "mdm":
+		// The host category does not allow creation or deletion, a system added or removed is a change to it
+		changeType := ecsChangeType(eventType)
+		if changeType == "creation" || changeType == "deletion" {
+			changeType = "change"
+		}
+		return []string{"host"}, []string{changeType}
+	case strings.Contains(eventType, "group"):
+		return []string{"iam"}, []string{"group", ecsChangeType(eventType)}
+	case strings.HasPrefix(eventType, "user_") || strings.HasPrefix(eventType, "admin_"):
+		return []string{"iam"}, []string{strings.SplitN(eventType, "_", 2)[0], ecsChangeType(eventType)}
+	}
+	return []string{"configuration"}, []string{ecsChangeType(eventType)}
+}
+
+// isAuthentication returns true for events that record a login or an authentication attempt
+func isAuthentication(service string, eventType string) bool {
+	switch service {
+	case "sso", "radius":
+		return true
+	case "ldap":
+		return eventType == "ldap_bind"
+	}
+	return strings.Contains(eventType, "login") || strings.HasSuffix(eventType, "_auth")
+}
+
+// ecsChangeType returns the ECS event.type for the change an event_type such as user_create describes
+func ecsChangeType(eventType string) string {
+	switch {
+	case strings.HasSuffix(eventType, "_create") || strings.HasSuffix(eventType, "_add"):
+		return "creation"
+	case strings.HasSuffix(eventType, "_delete") || strings.HasSuffix(eventType, "_remove"):
+		return "deletion"
+	case strings.HasSuffix(eventType, "_update") || strings.HasSuffix(eventType, "_change") ||
+		strings.HasSuffix(eventType, "_modify"):
+		return "change"
+	}
+	return "info"
+}
@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// formatTestEvent decodes a single JumpCloud event and formats it the way the output would
+func formatTestEvent(t *testing.T, raw string, output OutputConfig) string {
+	t.Helper()
+	var payloads [][]byte
+	_, err := decodeJumpCloudEventStream(strings.NewReader("["+raw+"]"), false, func(x JumpCloudEvent) error {
+		payload, err := wazuhPayload(x)
+		payloads = append(payloads, payload)
+		return err
+	})
+	if err != nil || len(payloads) != 1 {
+		t.Fatalf("decoding %v got %v events, error = %v", raw, len(payloads), err)
+	}
+	out := &recordingSink{}
+	err = newFormatSink(out, output.formatter()).WriteEvent(payloads[0])
+	if err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	return out.events[0]
+}
+
+func Test_formatECS(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  map[string]interface{}
+	}{
+		{
+			name: "TestFormatECSDirectoryLogin",
+			event: `{"service":"directory","event_type":"user_login_attempt","success":false,"id":"dir-1",` +
+				`"timestamp":"2023-02-15T10:00:00Z","client_ip":"203.0.113.9","error_message":"bad password",` +
+				`"initiated_by":{"id":"u1","type":"user","username":"jdoe","email":"jdoe@example.com"},` +
+				`"geoip":{"country_code":"US","region_code":"IL","region_name":"Illinois","timezone":"America/Chicago","continent_code":"NA","latitude":41.85,"longitude":-87.65},` +
+				`"useragent":{"name":"Chrome","version":"110.0.0","os_name":"Mac OS X","os_version":"13.2","os_full":"Mac OS X 13.2","device":"Mac"}}`,
+			want: map[string]interface{}{
+				"@timestamp":                  "2023-02-15T10:00:00Z",
+				"event.id":                    "dir-1",
+				"event.action":                "user_login_attempt",
+				"event.dataset":               "jumpcloud.directory",
+				"event.category":              "[authentication]",
+				"event.type":                  "[start]",
+				"event.outcome":               "failure",
+				"error.message":               "bad password",
+				"user.id":                     "u1",
+				"user.name":                   "jdoe",
+				"user.email":                  "jdoe@example.com",
+				"source.ip":                   "203.0.113.9",
+				"source.geo.country_iso_code": "US",
+				"source.geo.region_iso_code":  "US-IL",
+				"source.geo.region_name":      "Illinois",
+				"source.geo.timezone":         "America/Chicago",
+				"source.geo.location.lat":     "41.85",
+				"source.geo.location.lon":     "-87.65",
+				"user_agent.name":             "Chrome",
+				"user_agent.os.name":          "Mac OS X",
+				"user_agent.os.full":          "Mac OS X 13.2",
+				"user_agent.device.name":      "Mac",
+				"jumpcloud.initiated_by.type": "user",
+			},
+		},
+		{
+			name:  "TestFormatECSSSOTokenSuccess",
+			event: `{"service":"sso","event_type":"sso_auth","sso_token_success":true,"id":"sso-1","timestamp":"2023-02-15T10:00:03Z","application":{"name":"slack"}}`,
+			want: map[string]interface{}{
+				"event.category":             "[authentication]",
+				"event.outcome":              "success",
+				"jumpcloud.application.name": "slack",
+				"source.geo":                 nil,
+				"user_agent":                 nil,
+			},
+		},
+		{
+			name:  "TestFormatECSAdminCreatesUser",
+			event: `{"service":"directory","event_type":"user_create","success":true,"id":"dir-2","timestamp":"2023-02-15T10:00:00Z","initiated_by":{"type":"admin","email":"admin@example.com"},"resource":{"id":"r1","type":"user","username":"newhire"}}`,
+			want: map[string]interface{}{
+				"event.category":   "[iam]",
+				"event.type":       "[user creation]",
+				"user.email":       "admin@example.com",
+				"user.target.name": "newhire",
+			},
+		},
+		{
+			name:  "TestFormatECSAdminEventHasNoOutcome",
+			event: `{"service":"admin","event_type":"admin_update","id":"admin-1","timestamp":"2023-02-15T10:00:05Z"}`,
+			want: map[string]interface{}{
+				"event.category": "[iam]",
+				"event.type":     "[admin change]",
+				"event.outcome":  "unknown",
+			},
+		},
+		{
+			name:  "TestFormatECSEventWithoutSuccessHasNoOutcome",
+			event: `{"service":"directory","event_type":"user_update","id":"dir-4","timestamp":"2023-02-15T10:00:00Z","initiated_by":{"type":"admin","email":"admin@example.com"}}`,
+			want: map[string]interface{}{
+				"event.outcome":     "unknown",
+				"jumpcloud.success": nil,
+			},
+		},
+		{
+			name:  "TestFormatECSSoftwareAdd",
+			event: `{"service":"software","event_type":"software_add","id":"software-1","timestamp":"2023-02-15T10:00:08Z","software":{"name":"Firefox","version":"110.0"},"system":{"id":"s1","hostname":"mac-01"}}`,
+			want: map[string]interface{}{
+				"event.category":  "[package]",
+				"event.type":      "[installation]",
+				"package.name":    "Firefox",
+				"package.version": "110.0",
+				"host.id":         "s1",
+				"host.hostname":   "mac-01",
+			},
+		},
+		{
+			name:  "TestFormatECSSystemDeleteIsHostChange",
+			event: `{"service":"systems","event_type":"system_delete","id":"sys-2","timestamp":"2023-02-15T10:00:09Z","system":{"id":"s1","hostname":"mac-01"}}`,
+			want: map[string]interface{}{
+				"event.category": "[host]",
+				"event.type":     "[change]",
+				"host.id":        "s1",
+			},
+		},
+		{
+			name:  "TestFormatECSMDMEnrollIsHostChange",
+			event: `{"service":"mdm","event_type":"device_add","id":"mdm-2","timestamp":"2023-02-15T10:00:10Z"}`,
+			want: map[string]interface{}{
+				"event.category": "[host]",
+				"event.type":     "[change]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeEventDocument([]byte(formatTestEvent(t, tt.event, OutputConfig{Format: FormatECS})))
+			if err != nil {
+				t.Fatalf("formatECS() did not return JSON: %v", err)
+			}
+			if got.str("ecs.version") != ecsVersion || got.str("event.module") != "jumpcloud" {
+				t.Errorf("formatECS() got = %v, missing the ecs version or event module", got)
+			}
+			for path, want := range tt.want {
+				value := got.get(path)
+				if want == nil {
+					if value != nil {
+						t.Errorf("formatECS() %v got = %v, want it missing", path, value)
+					}
+					continue
+				}
+				if fmt.Sprint(value) != want {
+					t.Errorf("formatECS() %v got = %v, want %v", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputConfigFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{name: "TestOutputConfigFormatDefault", format: ""},
+		{name: "TestOutputConfigFormatWazuh", format: FormatWazuh},
+		{name: "TestOutputConfigFormatECS", format: FormatECS},
+		{name: "TestOutputConfigFormatOCSF", format: FormatOCSF},
+		{name: "TestOutputConfigFormatCEF", format: FormatCEF},
+		{name: "TestOutputConfigFormatLEEF", format: FormatLEEF},
+		{name: "TestOutputConfigFormatFlat", format: FormatFlat},
+		{name: "TestOutputConfigFormatUnknown", format: "splunk", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OutputConfig{Type: OutputStdout, Format: tt.format}.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatSinkPassesWazuhThrough(t *testing.T) {
+	out := &recordingSink{}
+	if newFormatSink(out, OutputConfig{Format: FormatWazuh}.formatter()) != EventSink(out) {
+		t.Errorf("newFormatSink() wrapped a sink that needs no formatting")
+	}
+	err := newFormatSink(out, formatECS).WriteEvent([]byte("not json"))
+	if err == nil {
+		t.Errorf("WriteEvent() error = nil, want an error for an event that is not JSON")
+	}
+	if len(out.events) != 0 {
+		t.Errorf("WriteEvent() wrote %v events for an event that could not be formatted", len(out.events))
+	}
+}
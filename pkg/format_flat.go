@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Separators the flat format can join keys with
+const (
+	defaultKeySeparator = "."
+	underscoreSeparator = "_"
+)
+
+// zeroTime is how an unset time.Time is written, such as by an event built in code
+const zeroTime = "0001-01-01T00:00:00Z"
+
+// validKeySeparator returns an error if separator can not join the keys of the flat format
+func validKeySeparator(separator string) error {
+	switch separator {
+	case "", defaultKeySeparator, underscoreSeparator:
+		return nil
+	}
+	return fmt.Errorf("unknown key_separator %q, expected . or _", separator)
+}
+
+// flatFormatter formats events as a single level JSON object, nested keys are joined with separator so
+// initiated_by.type is a key of its own
+type flatFormatter struct {
+	separator string
+}
+
+// format flattens an event.  Empty strings, zero numbers and timestamps, nulls and empty objects and lists are
+// dropped so alerts only carry fields with a value.  Booleans are always kept because the rules match success being
+// false
+func (f flatFormatter) format(event eventDocument) ([]byte, error) {
+	flat := map[string]interface{}{}
+	f.flatten(flat, "", event)
+	return json.Marshal(flat)
+}
+
+func (f flatFormatter) flatten(flat map[string]interface{}, prefix string, object map[string]interface{}) {
+	for key, value := range object {
+		if prefix != "" {
+			key = prefix + f.separator + key
+		}
+		if m, ok := value.(map[string]interface{}); ok {
+			f.flatten(flat, key, m)
+			continue
+		}
+		if !isZeroValue(value) {
+			flat[key] = value
+		}
+	}
+}
+
+// isZeroValue returns true for values that carry nothing, booleans are never zero
+func isZeroValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == "" || v == zeroTime
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func Test_flatFormatter_format(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+		event     string
+		want      string
+	}{
+		{
+			name:      "TestFlatDropsEmptyObjects",
+			separator: ".",
+			event:     `{"service":"systems","event_type":"login_attempt","success":false,"id":"sys-1","timestamp":"2023-02-15T10:00:02Z","username":"jdoe","initiated_by":{"type":"user"}}`,
+			want: `{"event_type":"login_attempt","id":"sys-1","initiated_by.type":"user","jumpcloud_event_type":"system",` +
+				`"service":"systems","success":false,"timestamp":"2023-02-15T10:00:02Z","username":"jdoe"}`,
+		},
+		{
+			name:      "TestFlatUnderscoreKeepsNumbersAndLists",
+			separator: "_",
+			event: `{"service":"software","event_type":"software_update","id":"software-1","timestamp":"2023-02-15T10:00:08Z",` +
+				`"software":{"name":"Firefox","version":"110.0"},"changes":[{"field":"version","from":"109.0","to":"110.0"}]}`,
+			want: `{"changes":[{"field":"version","from":"109.0","to":"110.0"}],"event_type":"software_update","id":"software-1",` +
+				`"jumpcloud_event_type":"software","service":"software","software_name":"Firefox","software_version":"110.0",` +
+				`"timestamp":"2023-02-15T10:00:08Z"}`,
+		},
+		{
+			name:      "TestFlatKeepsGeoip",
+			separator: ".",
+			event:     `{"service":"radius","event_type":"radius_auth_attempt","success":true,"mfa":false,"id":"radius-1","timestamp":"2023-02-15T10:00:04Z","geoip":{"country_code":"US","latitude":41.85,"longitude":0}}`,
+			want: `{"event_type":"radius_auth_attempt","geoip.country_code":"US","geoip.latitude":41.85,"id":"radius-1",` +
+				`"jumpcloud_event_type":"radius","mfa":false,"service":"radius","success":true,"timestamp":"2023-02-15T10:00:04Z"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatTestEvent(t, tt.event, OutputConfig{Format: FormatFlat, KeySeparator: tt.separator})
+			if got != tt.want {
+				t.Errorf("format() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validKeySeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+		wantErr   bool
+	}{
+		{name: "TestKeySeparatorDefault", separator: ""},
+		{name: "TestKeySeparatorDot", separator: "."},
+		{name: "TestKeySeparatorUnderscore", separator: "_"},
+		{name: "TestKeySeparatorOther", separator: "/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validKeySeparator(tt.separator); (err != nil) != tt.wantErr {
+				t.Errorf("validKeySeparator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
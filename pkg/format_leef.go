@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// leefTimeFormat is how devTime is written and leefDevTimeFormat tells QRadar how to read it
+const (
+	leefTimeFormat    = "2006-01-02T15:04:05.000-0700"
+	leefDevTimeFormat = "yyyy-MM-dd'T'HH:mm:ss.SSSZ"
+)
+
+// formatLEEF formats an event as QRadar LEEF 1.0 with tab separated attributes.  The event ID is the event_type,
+// the key fields use the predefined attributes and the rest are custom attributes
+func (h siemHeader) formatLEEF(event eventDocument) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("LEEF:1.0|")
+	for _, field := range []string{h.Vendor, h.Product, h.Version, event.str("event_type")} {
+		b.WriteString(escapeLEEFHeader(field))
+		b.WriteByte('|')
+	}
+
+	var fields siemFields
+	if t, err := time.Parse(time.RFC3339Nano, event.str("timestamp")); err == nil {
+		fields.add("devTime", t.UTC().Format(leefTimeFormat))
+		fields.add("devTimeFormat", leefDevTimeFormat)
+	}
+	fields.add("cat", event.str("service"))
+	fields.add("sev", strconv.Itoa(siemSeverity(event)))
+	fields.add("src", event.str("client_ip"))
+	fields.add("usrName", siemUser(event))
+	fields.add("accountName", event.str("resource.username"))
+	fields.add("identHostName", event.str("system.hostname"))
+	fields.add("eventId", event.str("id"))
+	fields.add("outcome", cefOutcome(event))
+	if mfa, ok := event.bool("mfa"); ok {
+		fields.add("mfa", strconv.FormatBool(mfa))
+	}
+	fields.add("userId", event.str("initiated_by.id"))
+	fields.add("organization", event.str("organization"))
+	fields.add("application", event.firstStr("application.display_label", "application.name"))
+	fields.add("country", event.str("geoip.country_code"))
+	fields.add("userAgent", siemUserAgent(event))
+	fields.add("reason", event.str("error_message"))
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(f.key + "=" + escapeLEEFValue(f.value))
+	}
+	return []byte(b.String()), nil
+}
+
+// escapeLEEFHeader escapes a LEEF header field, a header can not span lines so line breaks become spaces
+func escapeLEEFHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+// escapeLEEFValue escapes an attribute value so it can not break the event into more attributes or lines
+func escapeLEEFValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
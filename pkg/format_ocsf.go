@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ocsfVersion is the version of the Open Cybersecurity Schema Framework events are mapped to
+const ocsfVersion = "1.1.0"
+
+// OCSF classes events are mapped to
+const (
+	ocsfBaseEvent        = 0
+	ocsfAccountChange    = 3001
+	ocsfAuthentication   = 3002
+	ocsfEntityManagement = 3004
+)
+
+var ocsfClassNames = map[int]string{
+	ocsfBaseEvent:        "Base Event",
+	ocsfAccountChange:    "Account Change",
+	ocsfAuthentication:   "Authentication",
+	ocsfEntityManagement: "Entity Management",
+}
+
+// ocsfOther is the activity_id of an activity the class does not name
+const ocsfOther = 99
+
+// Activities of the Account Change class
+var ocsfAccountActivities = []struct {
+	match    string
+	id       int
+	activity string
+}{
+	{"_create", 1, "Create"},
+	{"_activate", 2, "Enable"},
+	{"password_change", 3, "Password Change"},
+	{"password_reset", 4, "Password Reset"},
+	{"_suspend", 5, "Disable"},
+	{"_deactivate", 5, "Disable"},
+	{"_delete", 6, "Delete"},
+	{"_lockout", 9, "Lock"},
+	{"_locked", 9, "Lock"},
+	{"mfa_enroll", 10, "MFA Factor Enable"},
+	{"mfa_unenroll", 11, "MFA Factor Disable"},
+}
+
+// formatOCSF maps an event to an OCSF class.  Logins become Authentication, changes to user and admin accounts
+// Account Change and other directory changes Entity Management, anything else is a Base Event.  The original event
+// is kept under unmapped so nothing JumpCloud sent is lost
+func formatOCSF(event eventDocument) ([]byte, error) {
+	service := event.str("service")
+	eventType := event.str("event_type")
+	class, activityID, activity := ocsfClassification(service, eventType)
+	statusID, status := ocsfStatus(event)
+	severityID, severity := 1, "Informational"
+	if statusID == 2 {
+		severityID, severity = 2, "Low"
+	}
+
+	ocsf := map[string]interface{}{}
+	set(ocsf, "category_uid", class/1000)
+	set(ocsf, "category_name", "Uncategorized")
+	if class != ocsfBaseEvent {
+		set(ocsf, "category_name", "Identity & Access Management")
+	}
+	set(ocsf, "class_uid", class)
+	set(ocsf, "class_name", ocsfClassNames[class])
+	set(ocsf, "activity_id", activityID)
+	set(ocsf, "activity_name", activity)
+	set(ocsf, "type_uid", class*100+activityID)
+	set(ocsf, "type_name", ocsfClassNames[class]+": "+activity)
+	if t, err := time.Parse(time.RFC3339Nano, event.str("timestamp")); err == nil {
+		set(ocsf, "time", t.UnixMilli())
+	}
+	set(ocsf, "severity_id", severityID)
+	set(ocsf, "severity", severity)
+	set(ocsf, "status_id", statusID)
+	set(ocsf, "status", status)
+	set(ocsf, "status_detail", event.str("error_message"))
+
+	set(ocsf, "metadata.version", ocsfVersion)
+	set(ocsf, "metadata.product.name", "Directory Insights")
+	set(ocsf, "metadata.product.vendor_name", "JumpCloud")
+	set(ocsf, "metadata.uid", event.str("id"))
+	set(ocsf, "metadata.original_time", event.str("timestamp"))
+	set(ocsf, "metadata.log_name", service)
+	set(ocsf, "metadata.event_code", eventType)
+	set(ocsf, "metadata.tenant_uid", event.str("organization"))
+
+	set(ocsf, "src_endpoint.ip", event.str("client_ip"))
+	set(ocsf, "src_endpoint.location", ocsfLocation(event))
+	set(ocsf, "src_endpoint.os", ocsfOS(event))
+	// JumpCloud only sends the parsed user agent, http_request.user_agent holds the raw header so it is left out
+
+	switch class {
+	case ocsfAuthentication:
+		// LDAP, RADIUS and system logins name the user at the top level of the event
+		user := ocsfUser(event, "initiated_by")
+		if _, ok := user["name"]; !ok {
+			set(user, "name", event.str("username"))
+		}
+		set(ocsf, "user", user)
+		if mfa, ok := event.bool("mfa"); ok {
+			set(ocsf, "is_mfa", mfa)
+		}
+		protocolID, protocol := ocsfAuthProtocol(service, event.str("application.sso_type"))
+		if protocolID != 0 {
+			set(ocsf, "auth_protocol_id", protocolID)
+			set(ocsf, "auth_protocol", protocol)
+		}
+		set(ocsf, "service.name", event.firstStr("application.display_label", "application.name"))
+		set(ocsf, "service.uid", event.str("application.id"))
+		set(ocsf, "dst_endpoint.hostname", event.str("system.hostname"))
+		set(ocsf, "dst_endpoint.uid", event.str("system.id"))
+	case ocsfAccountChange:
+		set(ocsf, "user", ocsfUser(event, "resource"))
+		set(ocsf, "actor.user", ocsfUser(event, "initiated_by"))
+	case ocsfEntityManagement:
+		set(ocsf, "entity.uid", event.str("resource.id"))
+		set(ocsf, "entity.type", event.str("resource.type"))
+		set(ocsf, "entity.name", event.firstStr("resource.name", "resource.displayName", "resource.username"))
+		set(ocsf, "actor.user", ocsfUser(event, "initiated_by"))
+	default:
+		set(ocsf, "actor.user", ocsfUser(event, "initiated_by"))
+		set(ocsf, "device.hostname", event.str("system.hostname"))
+		set(ocsf, "device.uid", event.str("system.id"))
+	}
+
+	ocsf["unmapped"] = map[string]interface{}(event)
+	return json.Marshal(ocsf)
+}
+
+// ocsfClassification returns the class, activity_id and activity name of an event
+func ocsfClassification(service string, eventType string) (int, int, string) {
+	switch {
+	case isAuthentication(service, eventType):
+		if strings.Contains(eventType, "logout") {
+			return ocsfAuthentication, 2, "Logoff"
+		}
+		return ocsfAuthentication, 1, "Logon"
+	case (strings.HasPrefix(eventType, "user_") || strings.HasPrefix(eventType, "admin_")) &&
+		!strings.Contains(eventType, "group"):
+		for _, a := range ocsfAccountActivities {
+			if strings.Contains(eventType, a.match) {
+				return ocsfAccountChange, a.id, a.activity
+			}
+		}
+		return ocsfAccountChange, ocsfOther, "Other"
+	case service == "directory" || service == "admin":
+		switch ecsChangeType(eventType) {
+		case "creation":
+			return ocsfEntityManagement, 1, "Create"
+		case "change":
+			return ocsfEntityManagement, 3, "Update"
+		case "deletion":
+			return ocsfEntityManagement, 4, "Delete"
+		}
+		return ocsfEntityManagement, ocsfOther, "Other"
+	}
+	return ocsfBaseEvent, ocsfOther, "Other"
+}
+
+// ocsfStatus returns the status_id and status of an event, SSO events report success as sso_token_success
+func ocsfStatus(event eventDocument) (int, string) {
+	switch ecsOutcome(event) {
+	case "success":
+		return 1, "Success"
+	case "failure":
+		return 2, "Failure"
+	}
+	return 0, "Unknown"
+}
+
+// ocsfUser returns the OCSF user described by an object of the event such as initiated_by, empty if it is missing
+func ocsfUser(event eventDocument, object string) map[string]interface{} {
+	user := map[string]interface{}{}
+	set(user, "name", event.firstStr(object+".username", object+".name"))
+	set(user, "uid", event.str(object+".id"))
+	set(user, "email_addr", event.str(object+".email"))
+	if typeID, typeName := ocsfUserType(event.str(object + ".type")); typeID != 0 {
+		user["type_id"], user["type"] = typeID, typeName
+	}
+	return user
+}
+
+// ocsfUserType returns the OCSF user type_id and type of a JumpCloud initiated_by or resource type, 0 if it has none
+func ocsfUserType(t string) (int, string) {
+	switch t {
+	case "user":
+		return 1, "User"
+	case "admin":
+		return 2, "Admin"
+	}
+	return 0, ""
+}
+
+// ocsfAuthProtocol returns the auth_protocol_id and auth_protocol of a login
+func ocsfAuthProtocol(service string, ssoType string) (int, string) {
+	switch {
+	case service == "radius":
+		return 10, "RADIUS"
+	case service == "ldap":
+		return ocsfOther, "LDAP"
+	case service == "sso" && strings.EqualFold(ssoType, "saml"):
+		return 5, "SAML"
+	case service == "sso" && strings.EqualFold(ssoType, "oidc"):
+		return 4, "OpenID"
+	}
+	return 0, ""
+}
+
+// ocsfLocation returns the OCSF location of the client of an event, or nil if JumpCloud did not locate it
+func ocsfLocation(event eventDocument) map[string]interface{} {
+	location := map[string]interface{}{}
+	set(location, "country", event.str("geoip.country_code"))
+	set(location, "region", event.str("geoip.region_code"))
+	set(location, "desc", event.str("geoip.region_name"))
+	set(location, "continent", event.str("geoip.continent_code"))
+	if lat, lon := event.number("geoip.latitude"), event.number("geoip.longitude"); lat != "" && lon != "" {
+		set(location, "coordinates", []json.Number{lon, lat})
+	}
+	if len(location) == 0 {
+		return nil
+	}
+	return location
+}
+
+// ocsfOS returns the OCSF operating system of the client of an event, or nil if JumpCloud did not report one
+func ocsfOS(event eventDocument) map[string]interface{} {
+	name := event.firstStr("useragent.os_name", "useragent.os")
+	if name == "" {
+		return nil
+	}
+	os := map[string]interface{}{"name": name, "type_id": 0, "type": "Unknown"}
+	set(os, "version", event.str("useragent.os_version"))
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "windows"):
+		os["type_id"], os["type"] = 100, "Windows"
+	case strings.Contains(lower, "ios") && !strings.Contains(lower, "mac"):
+		os["type_id"], os["type"] = 301, "iOS"
+	case strings.Contains(lower, "mac"):
+		os["type_id"], os["type"] = 300, "macOS"
+	case strings.Contains(lower, "android"):
+		os["type_id"], os["type"] = 201, "Android"
+	case strings.Contains(lower, "linux") || strings.Contains(lower, "ubuntu"):
+		os["type_id"], os["type"] = 200, "Linux"
+	}
+	return os
+}
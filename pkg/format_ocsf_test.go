@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// updateGolden rewrites the golden files from the current output, run go test ./pkg -run OCSF -update
+var updateGolden = flag.Bool("update", false, "rewrite golden files")
+
+// checkGolden compares got, indented, to the golden file at path
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	var indented bytes.Buffer
+	err := json.Indent(&indented, got, "", "  ")
+	if err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	indented.WriteByte('\n')
+	if *updateGolden {
+		err = os.WriteFile(path, indented.Bytes(), 0644)
+		if err != nil {
+			t.Fatalf("error updating %v: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading golden file: %v", err)
+	}
+	if !bytes.Equal(indented.Bytes(), want) {
+		t.Errorf("output does not match %v\ngot:\n%s\nwant:\n%s", path, indented.Bytes(), want)
+	}
+}
+
+func Test_formatOCSF(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  string
+		golden string
+	}{
+		{
+			name: "TestFormatOCSFAuthentication",
+			event: `{"service":"directory","event_type":"user_login_attempt","success":false,"mfa":true,"id":"dir-1",` +
+				`"organization":"org-1","timestamp":"2023-02-15T10:00:00.123Z","client_ip":"203.0.113.9","error_message":"bad password",` +
+				`"initiated_by":{"id":"u1","type":"user","username":"jdoe","email":"jdoe@example.com"},` +
+				`"geoip":{"country_code":"US","region_code":"IL","region_name":"Illinois","timezone":"America/Chicago","continent_code":"NA","latitude":41.85,"longitude":-87.65},` +
+				`"useragent":{"name":"Chrome","version":"110.0.0","os_name":"Mac OS X","os_version":"13.2","os_full":"Mac OS X 13.2","device":"Mac"}}`,
+			golden: "authentication.json",
+		},
+		{
+			name:   "TestFormatOCSFRadiusAuthentication",
+			event:  `{"service":"radius","event_type":"radius_auth_attempt","success":true,"mfa":false,"id":"radius-1","timestamp":"2023-02-15T10:00:04Z","username":"jdoe","client_ip":"10.0.0.1"}`,
+			golden: "authentication_radius.json",
+		},
+		{
+			name: "TestFormatOCSFAccountChange",
+			event: `{"service":"directory","event_type":"user_create","success":true,"id":"dir-2","organization":"org-1","timestamp":"2023-02-15T10:00:00Z",` +
+				`"client_ip":"198.51.100.7","initiated_by":{"id":"a1","type":"admin","email":"admin@example.com"},"resource":{"id":"u2","type":"user","username":"newhire"}}`,
+			golden: "account_change.json",
+		},
+		{
+			name:   "TestFormatOCSFEntityManagement",
+			event:  `{"service":"directory","event_type":"system_update","success":true,"id":"dir-3","timestamp":"2023-02-15T10:00:00Z","initiated_by":{"id":"a1","type":"admin","email":"admin@example.com"},"resource":{"id":"s1","type":"system","displayName":"mac-01"}}`,
+			golden: "entity_management.json",
+		},
+		{
+			name:   "TestFormatOCSFBaseEvent",
+			event:  `{"service":"mdm","event_type":"mdm_command_result","success":true,"id":"mdm-1","timestamp":"2023-02-15T10:00:06Z","command":{"type":"DeviceLock","status":"Acknowledged"},"system":{"id":"s1","hostname":"mac-01"}}`,
+			golden: "base_event.json",
+		},
+		{
+			name:   "TestFormatOCSFEventWithoutSuccess",
+			event:  `{"service":"mdm","event_type":"mdm_command_result","id":"mdm-2","timestamp":"2023-02-15T10:00:06Z","command":{"type":"DeviceLock","status":"Acknowledged"},"system":{"id":"s1","hostname":"mac-01"}}`,
+			golden: "base_event_without_success.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatTestEvent(t, tt.event, OutputConfig{Format: FormatOCSF})
+			checkGolden(t, filepath.Join("..", "test_data", "ocsf", tt.golden), []byte(got))
+		})
+	}
+}
+
+func Test_ocsfClassification(t *testing.T) {
+	tests := []struct {
+		name         string
+		service      string
+		eventType    string
+		wantClass    int
+		wantActivity int
+	}{
+		{name: "TestOCSFSSOLogin", service: "sso", eventType: "sso_auth", wantClass: ocsfAuthentication, wantActivity: 1},
+		{name: "TestOCSFLDAPBind", service: "ldap", eventType: "ldap_bind", wantClass: ocsfAuthentication, wantActivity: 1},
+		{name: "TestOCSFLDAPSearch", service: "ldap", eventType: "ldap_search", wantClass: ocsfBaseEvent, wantActivity: ocsfOther},
+		{name: "TestOCSFSystemLogin", service: "systems", eventType: "login_attempt", wantClass: ocsfAuthentication, wantActivity: 1},
+		{name: "TestOCSFUserDelete", service: "directory", eventType: "user_delete", wantClass: ocsfAccountChange, wantActivity: 6},
+		{name: "TestOCSFPasswordReset", service: "directory", eventType: "user_password_reset", wantClass: ocsfAccountChange, wantActivity: 4},
+		{name: "TestOCSFAdminUpdate", service: "admin", eventType: "admin_update", wantClass: ocsfAccountChange, wantActivity: ocsfOther},
+		{name: "TestOCSFGroupCreate", service: "directory", eventType: "user_group_create", wantClass: ocsfEntityManagement, wantActivity: 1},
+		{name: "TestOCSFSoftware", service: "software", eventType: "software_add", wantClass: ocsfBaseEvent, wantActivity: ocsfOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, activity, _ := ocsfClassification(tt.service, tt.eventType)
+			if class != tt.wantClass || activity != tt.wantActivity {
+				t.Errorf("ocsfClassification() got = %v %v, want %v %v", class, activity, tt.wantClass, tt.wantActivity)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_eventDocumentEncode(t *testing.T) {
+	event := eventDocument{
+		"html":     "<script>&amp;</script>",
+		"controls": "tab\there\nnew\rline\bback\ffeed\x00\x1f\x7f\"quoted\" \\ slash",
+		"unicode":  "ünïcødé 日本    😀",
+		"invalid":  "bad \xff\xfe utf8",
+		"number":   json.Number("12345678901234567890"),
+		"empty":    json.Number(""),
+		"bools":    []interface{}{true, false, nil},
+		"nested":   map[string]interface{}{"b": "2", "a": []interface{}{map[string]interface{}{}}, "z": []interface{}{}},
+		"nulls":    map[string]interface{}{"list": []interface{}(nil), "map": map[string]interface{}(nil)},
+		"other":    3.5,
+	}
+	got, err := event.encode()
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	want, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("encode() got = %s, want %s", got, want)
+	}
+}
@@ -1,14 +1,23 @@
 package pkg
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// AllServices is the Directory Insights service name that returns events from every service in a single query
+const AllServices = "all"
+
 // Valid JumpCloud service types are:
 // all: Logs from all services.
 // directory: Logs activity in the Admin Portal and User Portal, including admin changes in the directory and admin/user authentications to the Admin Portal and User Portal.
@@ -19,12 +28,35 @@ import (
 // software: Logs application activity when software is added, removed, or changed on a macOS, Windows, or Linux device. Events are logged based on changes to an application version during each device check-in.
 // sso: Logs user authentications to SAML applications.
 // systems: Logs user authentications to MacOS, Windows, and Linux systems, including agent-related events on lockout, password changes, and File Disk Encryption key updates.
+// alerts: Logs alerts raised by JumpCloud alert rules and changes to their status.
+// object_storage: Logs access to files stored in JumpCloud, such as uploads and downloads.
+// saas_app_management: Logs discovery and management of SaaS applications used in the organization.
+// access_management: Logs user access requests and their approval or denial.
 
 // JumpCloudAPI can be used to interact with the JumpCloud API
 type JumpCloudAPI struct {
-	apiKey  string
-	baseURL string
-	orgID   string
+	apiKey    string
+	baseURL   string
+	orgID     string
+	services  []ServiceQuery
+	rawEvents bool
+	client    *http.Client
+	// readTimeout limits each read of a response body, see readDeadlineBody
+	readTimeout time.Duration
+	// maxRetries is how many times a failed query is sent again
+	maxRetries int
+	// sleep is sleepContext, tests replace it to avoid waiting on retries
+	sleep            func(context.Context, time.Duration) error
+	rateLimitedUntil time.Time
+}
+
+// ServiceQuery selects a JumpCloud service to collect events from, each service is queried separately so a noisy
+// service can not crowd out the others
+type ServiceQuery struct {
+	// Service is the Directory Insights service name, see the list above
+	Service string
+	// Limit is the number of events to request per page, defaults to the API maximum of 10000
+	Limit int
 }
 
 // NewJumpCloudAPIOptions are the options for creating a new JumpCloudAPI object
@@ -32,67 +64,349 @@ type NewJumpCloudAPIOptions struct {
 	APIKey  string
 	BaseURL string
 	OrgID   string
+	// Services are the services to collect events from, defaults to a single query for all services
+	Services []ServiceQuery
+	// RawEvents keeps every event exactly as JumpCloud sent it instead of decoding it into the typed structs
+	RawEvents bool
+	// ConnectTimeout limits connecting to JumpCloud, defaults to 10 seconds
+	ConnectTimeout time.Duration
+	// ReadTimeout limits waiting on JumpCloud to answer a query and then for each part of the answer, defaults to 2
+	// minutes.  Time spent writing events to the outputs while the answer is read does not count
+	ReadTimeout time.Duration
+	// MaxRetries is how many times a query that failed with a network error, throttling or a server error is sent
+	// again, defaults to 5.  Set it below zero to disable retries
+	MaxRetries int
 }
 
 // NewJumpCloudAPI returns a new JumpCloudAPI object, if you do not provide a base URL, it will default to the JumpCloud API
 func NewJumpCloudAPI(options NewJumpCloudAPIOptions) *JumpCloudAPI {
 	a := JumpCloudAPI{
-		apiKey:  options.APIKey,
-		baseURL: options.BaseURL,
-		orgID:   options.OrgID,
+		apiKey:     options.APIKey,
+		baseURL:    options.BaseURL,
+		orgID:      options.OrgID,
+		rawEvents:  options.RawEvents,
+		maxRetries: options.MaxRetries,
+		sleep:      sleepContext,
 	}
 	if options.BaseURL == "" {
 		a.baseURL = "https://api.jumpcloud.com"
 	}
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = defaultConnectTimeout
+	}
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = defaultReadTimeout
+	}
+	a.client = newHTTPClient(options.ConnectTimeout, options.ReadTimeout)
+	a.readTimeout = options.ReadTimeout
+	if options.MaxRetries == 0 {
+		a.maxRetries = defaultMaxRetries
+	}
+	if options.MaxRetries < 0 {
+		a.maxRetries = 0
+	}
+	for _, x := range options.Services {
+		if x.Limit <= 0 || x.Limit > insightsPageLimit {
+			x.Limit = insightsPageLimit
+		}
+		a.services = append(a.services, x)
+	}
+	if len(a.services) == 0 {
+		a.services = []ServiceQuery{{Service: AllServices, Limit: insightsPageLimit}}
+	}
 	return &a
 }
 
-// GetEventsSinceTime returns all JumpCloud events since the given time
+// insightsPageLimit is the largest page size the Directory Insights API will return for a single query
+const insightsPageLimit = 10000
+
+// insightsQuery is the request body sent to the Directory Insights events endpoint
+type insightsQuery struct {
+	Service     []string        `json:"service"`
+	StartTime   string          `json:"start_time"`
+	EndTime     string          `json:"end_time,omitempty"`
+	Limit       int             `json:"limit"`
+	Sort        string          `json:"sort,omitempty"`
+	SearchAfter json.RawMessage `json:"search_after,omitempty"`
+}
+
+// GetEventsSinceTime returns all JumpCloud events since the given time from every configured service.  If any
+// service fails the whole call fails so the caller never checkpoints past events it did not receive
 func (a *JumpCloudAPI) GetEventsSinceTime(startTime time.Time) (*JumpCloudEvents, error) {
-	url := a.baseURL + "/insights/directory/v1/events"
-	method := "POST"
-	// JumpCloud API requires a time in RFC3339 format
-	starterTime := startTime.Format(time.RFC3339)
-	payload := strings.NewReader(fmt.Sprintf(`{"service": ["all"], "start_time": "%v", "limit": 10000}`, starterTime))
-	// Default Go HTTP client, might need to customize this later
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
+	return a.GetEventsSinceTimeContext(context.Background(), startTime)
+}
+
+// GetEventsSinceTimeContext is GetEventsSinceTime with a context that can cancel the queries
+func (a *JumpCloudAPI) GetEventsSinceTimeContext(ctx context.Context, startTime time.Time) (*JumpCloudEvents, error) {
+	finished := JumpCloudEvents{}
+	for _, x := range a.services {
+		err := a.streamServiceEvents(ctx, x, startTime, sinceTimeEnd(), func(e JumpCloudEvent) error {
+			finished.add(e)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %v events: %w", x.Service, err)
+		}
+	}
+	return &finished, nil
+}
+
+// TestConnection runs the smallest possible query to check JumpCloud accepts the API key, org ID and base URL
+func (a *JumpCloudAPI) TestConnection() error {
+	return a.TestConnectionContext(context.Background())
+}
+
+// TestConnectionContext is TestConnection with a context that can cancel the query
+func (a *JumpCloudAPI) TestConnectionContext(ctx context.Context) error {
+	now := time.Now().UTC()
+	_, err := a.getEventsPage(ctx, insightsQuery{
+		Service:   []string{AllServices},
+		StartTime: now.Add(-time.Minute).Format(time.RFC3339),
+		EndTime:   now.Format(time.RFC3339),
+		Limit:     1,
+	}, func(JumpCloudEvent) error { return nil })
+	return err
+}
+
+// Services returns the name of every service the JumpCloudAPI queries
+func (a *JumpCloudAPI) Services() []string {
+	var services []string
+	for _, x := range a.services {
+		services = append(services, x.Service)
+	}
+	return services
+}
+
+// GetServiceEventsSinceTime returns the events of a single configured service since the given time
+func (a *JumpCloudAPI) GetServiceEventsSinceTime(service string, startTime time.Time) (*JumpCloudEvents, error) {
+	return a.GetServiceEventsSinceTimeContext(context.Background(), service, startTime)
+}
+
+// GetServiceEventsSinceTimeContext is GetServiceEventsSinceTime with a context that can cancel the queries
+func (a *JumpCloudAPI) GetServiceEventsSinceTimeContext(ctx context.Context, service string, startTime time.Time) (*JumpCloudEvents, error) {
+	return a.getServiceEvents(ctx, a.serviceQuery(service), startTime, sinceTimeEnd())
+}
+
+// GetServiceEventsBetween returns the events of a single configured service between the given times
+func (a *JumpCloudAPI) GetServiceEventsBetween(service string, startTime time.Time, endTime time.Time) (*JumpCloudEvents, error) {
+	return a.GetServiceEventsBetweenContext(context.Background(), service, startTime, endTime)
+}
+
+// GetServiceEventsBetweenContext is GetServiceEventsBetween with a context that can cancel the queries
+func (a *JumpCloudAPI) GetServiceEventsBetweenContext(ctx context.Context, service string, startTime time.Time, endTime time.Time) (*JumpCloudEvents, error) {
+	return a.getServiceEvents(ctx, a.serviceQuery(service), startTime, endTime)
+}
+
+// StreamServiceEventsSinceTime hands the events of a single configured service since the given time to handle one at
+// a time, oldest first, as they are decoded from the response.  Nothing is held in memory once handle returns.  If
+// handle returns an error no more events are decoded and the error is returned
+func (a *JumpCloudAPI) StreamServiceEventsSinceTime(ctx context.Context, service string, startTime time.Time, handle func(JumpCloudEvent) error) error {
+	return a.streamServiceEvents(ctx, a.serviceQuery(service), startTime, sinceTimeEnd(), handle)
+}
+
+// StreamServiceEventsBetween is StreamServiceEventsSinceTime for the events between the given times
+func (a *JumpCloudAPI) StreamServiceEventsBetween(ctx context.Context, service string, startTime time.Time, endTime time.Time, handle func(JumpCloudEvent) error) error {
+	return a.streamServiceEvents(ctx, a.serviceQuery(service), startTime, endTime, handle)
+}
+
+// sinceTimeEnd returns the end of the window the SinceTime calls query.  It is pinned to the time of the call so new
+// events arriving while we page do not keep the loop running forever, they will be picked up on the next run
+func sinceTimeEnd() time.Time {
+	return time.Now()
+}
+
+// serviceQuery returns the configured query for a service, or a query with the default limit if it is not configured
+func (a *JumpCloudAPI) serviceQuery(service string) ServiceQuery {
+	for _, x := range a.services {
+		if x.Service == service {
+			return x
+		}
+	}
+	return ServiceQuery{Service: service, Limit: insightsPageLimit}
+}
+
+// getServiceEvents returns the events of a single service between the given times, following the search_after
+// cursor until every page in the window has been read
+func (a *JumpCloudAPI) getServiceEvents(ctx context.Context, service ServiceQuery, startTime time.Time, endTime time.Time) (*JumpCloudEvents, error) {
+	finished := JumpCloudEvents{}
+	err := a.streamServiceEvents(ctx, service, startTime, endTime, func(x JumpCloudEvent) error {
+		finished.add(x)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return nil, err
+	}
+	return &finished, nil
+}
+
+// streamServiceEvents hands the events of a single service between the given times to handle as they are decoded,
+// following the search_after cursor until every page in the window has been read
+func (a *JumpCloudAPI) streamServiceEvents(ctx context.Context, service ServiceQuery, startTime time.Time, endTime time.Time, handle func(JumpCloudEvent) error) error {
+	query := insightsQuery{
+		Service:   []string{service.Service},
+		StartTime: startTime.UTC().Format(time.RFC3339),
+		EndTime:   endTime.UTC().Format(time.RFC3339),
+		Limit:     service.Limit,
+		Sort:      "ASC",
 	}
-	req.Header.Add("x-api-key", a.apiKey)
-	req.Header.Add("Content-Type", "application/json")
-	if a.orgID != "" {
-		req.Header.Add("x-org-id", a.orgID)
+	for {
+		page, err := a.getEventsPage(ctx, query, handle)
+		if err != nil {
+			return err
+		}
+		// A short page or a missing cursor means the window has been drained
+		if page.searchAfter == "" || page.resultCount < query.Limit {
+			return nil
+		}
+		if string(query.SearchAfter) == page.searchAfter {
+			return fmt.Errorf("JumpCloud returned the same search_after cursor twice: %v", page.searchAfter)
+		}
+		query.SearchAfter = json.RawMessage(page.searchAfter)
+	}
+}
+
+// eventsPage holds the pagination headers of a single page of results from the Directory Insights events endpoint
+type eventsPage struct {
+	searchAfter string
+	resultCount int
+}
+
+// getEventsPage runs a single Directory Insights query, hands every event in the page to handle and returns the
+// pagination headers
+func (a *JumpCloudAPI) getEventsPage(ctx context.Context, query insightsQuery, handle func(JumpCloudEvent) error) (*eventsPage, error) {
+	b, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %w", err)
 	}
-	res, err := client.Do(req)
+	res, err := a.postWithRetry(ctx, "/insights/directory/v1/events", b)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return nil, err
 	}
 	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
+	decoded, err := decodeJumpCloudEventStream(res.Body, a.rawEvents, handle)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v | %v | %v", res.Status, res.StatusCode, err)
+		return nil, err
 	}
-	// JumpCloud API returns a 200 even if there are no events
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("error response from JumpCloud: %v | %v | %v", res.Status, res.StatusCode, string(body))
+	page := eventsPage{
+		searchAfter: strings.TrimSpace(res.Header.Get("X-Search_after")),
 	}
-	events, err := decodeJumpCloudEvents(body)
+	// X-Result-Count is the number of events in this page, fall back to the number decoded if it is missing
+	page.resultCount, err = strconv.Atoi(res.Header.Get("X-Result-Count"))
 	if err != nil {
-		return nil, fmt.Errorf("error decoding JumpCloud response: %v", err)
+		page.resultCount = decoded
 	}
-	return &events, nil
+	return &page, nil
 }
 
 type JumpCloudEvents struct {
-	LDAP      []JumpCloudLDAPEvent      `json:"ldap_events"`
-	Systems   []JumpCloudSystemEvent    `json:"systems"`
-	Directory []JumpCloudDirectoryEvent `json:"directory"`
-	Radius    []JumpCloudRadiusEvent    `json:"radius"`
-	SSO       []JumpCloudSSOEvent       `json:"sso"`
-	Admin     []JumpCloudAdminEvent     `json:"admin"`
+	LDAP              []JumpCloudLDAPEvent              `json:"ldap_events"`
+	Systems           []JumpCloudSystemEvent            `json:"systems"`
+	Directory         []JumpCloudDirectoryEvent         `json:"directory"`
+	Radius            []JumpCloudRadiusEvent            `json:"radius"`
+	SSO               []JumpCloudSSOEvent               `json:"sso"`
+	Admin             []JumpCloudAdminEvent             `json:"admin"`
+	MDM               []JumpCloudMDMEvent               `json:"mdm"`
+	PasswordManager   []JumpCloudPasswordManagerEvent   `json:"password_manager"`
+	Software          []JumpCloudSoftwareEvent          `json:"software"`
+	Alerts            []JumpCloudAlertEvent             `json:"alerts"`
+	ObjectStorage     []JumpCloudObjectStorageEvent     `json:"object_storage"`
+	SaaSAppManagement []JumpCloudSaaSAppManagementEvent `json:"saas_app_management"`
+	AccessManagement  []JumpCloudAccessManagementEvent  `json:"access_management"`
+	Raw               []JumpCloudRawEvent               `json:"raw"`
+}
+
+// JumpCloudEvent is implemented by every decoded JumpCloud event type
+type JumpCloudEvent interface {
+	// wazuhDocument returns the event as the JSON object the Wazuh rules expect.  It is the object the event was
+	// decoded from, not a copy, so it is only taken once
+	wazuhDocument() eventDocument
+	getTimestamp() time.Time
+	getID() string
+}
+
+// add adds a single decoded event to the list for its type
+func (e *JumpCloudEvents) add(x JumpCloudEvent) {
+	switch v := x.(type) {
+	case *JumpCloudLDAPEvent:
+		e.LDAP = append(e.LDAP, *v)
+	case *JumpCloudSystemEvent:
+		e.Systems = append(e.Systems, *v)
+	case *JumpCloudDirectoryEvent:
+		e.Directory = append(e.Directory, *v)
+	case *JumpCloudRadiusEvent:
+		e.Radius = append(e.Radius, *v)
+	case *JumpCloudSSOEvent:
+		e.SSO = append(e.SSO, *v)
+	case *JumpCloudAdminEvent:
+		e.Admin = append(e.Admin, *v)
+	case *JumpCloudMDMEvent:
+		e.MDM = append(e.MDM, *v)
+	case *JumpCloudPasswordManagerEvent:
+		e.PasswordManager = append(e.PasswordManager, *v)
+	case *JumpCloudSoftwareEvent:
+		e.Software = append(e.Software, *v)
+	case *JumpCloudAlertEvent:
+		e.Alerts = append(e.Alerts, *v)
+	case *JumpCloudObjectStorageEvent:
+		e.ObjectStorage = append(e.ObjectStorage, *v)
+	case *JumpCloudSaaSAppManagementEvent:
+		e.SaaSAppManagement = append(e.SaaSAppManagement, *v)
+	case *JumpCloudAccessManagementEvent:
+		e.AccessManagement = append(e.AccessManagement, *v)
+	case *JumpCloudRawEvent:
+		e.Raw = append(e.Raw, *v)
+	}
+}
+
+// allEvents returns every event of every type as a single list, oldest first
+func (e *JumpCloudEvents) allEvents() []JumpCloudEvent {
+	var all []JumpCloudEvent
+	for i := range e.Directory {
+		all = append(all, &e.Directory[i])
+	}
+	for i := range e.LDAP {
+		all = append(all, &e.LDAP[i])
+	}
+	for i := range e.Systems {
+		all = append(all, &e.Systems[i])
+	}
+	for i := range e.SSO {
+		all = append(all, &e.SSO[i])
+	}
+	for i := range e.Radius {
+		all = append(all, &e.Radius[i])
+	}
+	for i := range e.Admin {
+		all = append(all, &e.Admin[i])
+	}
+	for i := range e.MDM {
+		all = append(all, &e.MDM[i])
+	}
+	for i := range e.PasswordManager {
+		all = append(all, &e.PasswordManager[i])
+	}
+	for i := range e.Software {
+		all = append(all, &e.Software[i])
+	}
+	for i := range e.Alerts {
+		all = append(all, &e.Alerts[i])
+	}
+	for i := range e.ObjectStorage {
+		all = append(all, &e.ObjectStorage[i])
+	}
+	for i := range e.SaaSAppManagement {
+		all = append(all, &e.SaaSAppManagement[i])
+	}
+	for i := range e.AccessManagement {
+		all = append(all, &e.AccessManagement[i])
+	}
+	for i := range e.Raw {
+		all = append(all, &e.Raw[i])
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].getTimestamp().Before(all[j].getTimestamp())
+	})
+	return all
 }
 
 type BaseJumpCloudEvent struct {
@@ -100,99 +414,256 @@ type BaseJumpCloudEvent struct {
 }
 
 // decodeJumpCloudEvents decodes the raw JumpCloud API response into a JumpCloudEvents object that contains events
-// of the varying types
-func decodeJumpCloudEvents(raw []byte) (JumpCloudEvents, error) {
+// of the varying types.  Events from services that are not modelled, or that fail to decode into their typed struct,
+// are kept as raw events.  When rawEvents is set every event is kept as a raw event
+func decodeJumpCloudEvents(raw []byte, rawEvents bool) (JumpCloudEvents, error) {
 	finished := JumpCloudEvents{}
-	generic := []map[string]interface{}{}
-	err := json.Unmarshal(raw, &generic)
+	_, err := decodeJumpCloudEventStream(bytes.NewReader(raw), rawEvents, func(x JumpCloudEvent) error {
+		finished.add(x)
+		return nil
+	})
 	if err != nil {
 		return JumpCloudEvents{}, err
 	}
-	var events []BaseJumpCloudEvent
-	err = json.Unmarshal(raw, &events)
-	for i, x := range events {
-		fmt.Println(x.Service)
-		switch x.Service {
-		case "ldap":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling LDAP generic event - will continue: %v\n", err)
-				continue
-			}
-			var e JumpCloudLDAPEvent
-			err = json.Unmarshal(b, &e)
-			if err != nil {
-				fmt.Printf("Error unmarshalling LDAP detailed event - will continue: %v\n", err)
-				continue
-			}
-			finished.LDAP = append(finished.LDAP, e)
-		case "systems":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling Systems generic event - will continue: %v\n", err)
-				continue
-			}
-			var e JumpCloudSystemEvent
-			err = json.Unmarshal(b, &e)
-			if err != nil {
-				fmt.Printf("Error unmarshalling Systems detailed event - will continue: %v\n", err)
-				continue
-			}
-			finished.Systems = append(finished.Systems, e)
-		case "directory":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling Directory generic event - will continue: %v\n", err)
-				continue
-			}
-			var e JumpCloudDirectoryEvent
-			err = json.Unmarshal(b, &e)
-			if err != nil {
-				fmt.Printf("Error unmarshalling Directory detailed event - will continue: %v\n", err)
-				continue
-			}
-			finished.Directory = append(finished.Directory, e)
-		case "radius":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling Radius generic event - will continue: %v\n", err)
-				continue
-			}
-			var e JumpCloudRadiusEvent
-			err = json.Unmarshal(b, &e)
-			if err != nil {
-				fmt.Printf("Error unmarshalling Radius detailed event - will continue: %v\n", err)
-				continue
-			}
-			finished.Radius = append(finished.Radius, e)
-		case "sso":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling SSO generic event - will continue: %v\n", err)
-				continue
+	return finished, nil
+}
+
+// decodeJumpCloudEventStream decodes a JSON array of events from r one element at a time and hands every event to
+// handle as soon as it is decoded.  Each element is parsed once, into the generic object that keeps every field
+// JumpCloud sent, and the typed struct of its service is filled from that object.  It returns how many events were
+// decoded, stopping at the first error from the stream or from handle
+func decodeJumpCloudEventStream(r io.Reader, rawEvents bool, handle func(JumpCloudEvent) error) (int, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, fmt.Errorf("error decoding JumpCloud response: %w", err)
+	}
+	if token != json.Delim('[') {
+		return 0, fmt.Errorf("error decoding JumpCloud response: expected an array of events, got %v", token)
+	}
+	decoded := 0
+	for decoder.More() {
+		var original eventDocument
+		err = decoder.Decode(&original)
+		if err != nil {
+			return decoded, fmt.Errorf("error decoding JumpCloud response: %w", err)
+		}
+		service := original.str("service")
+		var event JumpCloudEvent
+		if !rawEvents {
+			event = decodeTypedEvent(service, original)
+		}
+		if event == nil {
+			raw := newJumpCloudRawEvent(service, original)
+			event = &raw
+		}
+		decoded++
+		err = handle(event)
+		if err != nil {
+			return decoded, err
+		}
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return decoded, fmt.Errorf("error decoding JumpCloud response: %w", err)
+	}
+	return decoded, nil
+}
+
+// newTypedEvent returns an empty typed struct for the events of a service and its name for log messages, or nil if
+// the service is not modelled
+func newTypedEvent(service string) (typedEvent, string) {
+	switch service {
+	case "ldap":
+		return &JumpCloudLDAPEvent{}, "LDAP"
+	case "systems":
+		return &JumpCloudSystemEvent{}, "Systems"
+	case "directory":
+		return &JumpCloudDirectoryEvent{}, "Directory"
+	case "radius":
+		return &JumpCloudRadiusEvent{}, "Radius"
+	case "sso":
+		return &JumpCloudSSOEvent{}, "SSO"
+	case "admin":
+		return &JumpCloudAdminEvent{}, "Admin"
+	case "mdm":
+		return &JumpCloudMDMEvent{}, "MDM"
+	case "password_manager":
+		return &JumpCloudPasswordManagerEvent{}, "Password Manager"
+	case "software":
+		return &JumpCloudSoftwareEvent{}, "Software"
+	case "alerts":
+		return &JumpCloudAlertEvent{}, "Alerts"
+	case "object_storage":
+		return &JumpCloudObjectStorageEvent{}, "Object Storage"
+	case "saas_app_management":
+		return &JumpCloudSaaSAppManagementEvent{}, "SaaS App Management"
+	case "access_management":
+		return &JumpCloudAccessManagementEvent{}, "Access Management"
+	}
+	return nil, ""
+}
+
+// typedEvent is implemented by pointers to the detailed event types
+type typedEvent interface {
+	JumpCloudEvent
+	setOriginal(eventDocument)
+}
+
+// decodeTypedEvent fills the typed struct for the service of an event from its original object, keeping the object
+// so fields that are not modelled are still emitted.  It returns nil if the service is not modelled or the event does
+// not fit its struct
+func decodeTypedEvent(service string, original eventDocument) JumpCloudEvent {
+	e, name := newTypedEvent(service)
+	if e == nil {
+		logWarnf("Unknown JumpCloud service %q - passing event through unmodified", service)
+		return nil
+	}
+	err := fillStruct(reflect.ValueOf(e).Elem(), original)
+	if err != nil {
+		logWarnf("Error decoding %v detailed event - will pass it through unmodified: %v", name, err)
+		return nil
+	}
+	e.setOriginal(original)
+	return e
+}
+
+// structField is an exported field of a struct events are decoded into and the key it is read from
+type structField struct {
+	index int
+	name  string
+}
+
+// structFields caches the fields of every struct type events are decoded into, keyed by reflect.Type
+var structFields sync.Map
+
+// fieldsOf returns the fields of struct type t that are read from JSON, named as encoding/json names them
+func fieldsOf(t reflect.Type) []structField {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, structField{index: i, name: name})
+	}
+	structFields.Store(t, fields)
+	return fields
+}
+
+// fillStruct sets the fields of the struct v from a decoded JSON object the way json.Unmarshal would, except keys
+// must match exactly as JumpCloud always sends them as the structs name them.  Fields without a key are left as they
+// are
+func fillStruct(v reflect.Value, object map[string]interface{}) error {
+	for _, field := range fieldsOf(v.Type()) {
+		value, ok := object[field.name]
+		if !ok {
+			continue
+		}
+		err := fillValue(v.Field(field.index), value)
+		if err != nil {
+			return fmt.Errorf("%v: %w", field.name, err)
+		}
+	}
+	return nil
+}
+
+// timeType is the type of the timestamps of the typed events
+var timeType = reflect.TypeOf(time.Time{})
+
+// fillValue sets v from a decoded JSON value, a null leaves v unchanged.  Numbers in fields of any type are kept as
+// the json.Number of the original event so large integers are not rounded through float64
+func fillValue(v reflect.Value, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			v.SetString(s)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := value.(json.Number); ok {
+			i, err := strconv.ParseInt(string(n), 10, 64)
+			if err != nil || v.OverflowInt(i) {
+				return fmt.Errorf("number %v does not fit %v", n, v.Type())
 			}
-			var e JumpCloudSSOEvent
-			err = json.Unmarshal(b, &e)
+			v.SetInt(i)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := value.(json.Number); ok {
+			f, err := strconv.ParseFloat(string(n), v.Type().Bits())
 			if err != nil {
-				fmt.Printf("Error unmarshalling SSO detailed event - will continue: %v\n", err)
-				continue
+				return fmt.Errorf("number %v does not fit %v", n, v.Type())
 			}
-			finished.SSO = append(finished.SSO, e)
-		case "admin":
-			b, err := json.Marshal(generic[i])
-			if err != nil {
-				fmt.Printf("Error marshalling Admin generic event - will continue: %v\n", err)
-				continue
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(value))
+			return nil
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			if s, ok := value.(string); ok {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					return err
+				}
+				v.Set(reflect.ValueOf(t))
+				return nil
 			}
-			var e JumpCloudAdminEvent
-			err = json.Unmarshal(b, &e)
-			if err != nil {
-				fmt.Printf("Error unmarshalling Admin detailed event - will continue: %v\n", err)
-				continue
+			break
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			return fillStruct(v, object)
+		}
+	case reflect.Slice:
+		if list, ok := value.([]interface{}); ok {
+			filled := reflect.MakeSlice(v.Type(), len(list), len(list))
+			for i, x := range list {
+				err := fillValue(filled.Index(i), x)
+				if err != nil {
+					return err
+				}
 			}
-			finished.Admin = append(finished.Admin, e)
-
+			v.Set(filled)
+			return nil
 		}
 	}
-	return finished, nil
+	return fmt.Errorf("can not decode %v into %v", jsonKind(value), v.Type())
+}
+
+// jsonKind names the kind of a decoded JSON value for error messages
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
 }
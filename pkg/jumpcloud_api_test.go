@@ -0,0 +1,380 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestJumpCloudAPI returns a JumpCloudAPI that does not sleep between retries
+func newTestJumpCloudAPI(options NewJumpCloudAPIOptions) *JumpCloudAPI {
+	a := NewJumpCloudAPI(options)
+	a.sleep = func(context.Context, time.Duration) error { return nil }
+	return a
+}
+
+// pagedInsightsServer serves pages of SSO events, every page but the last is full and carries a search_after cursor
+func pagedInsightsServer(t *testing.T, pages [][]string) (*httptest.Server, *[]insightsQuery) {
+	var queries []insightsQuery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var q insightsQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Errorf("error decoding query: %v", err)
+		}
+		queries = append(queries, q)
+		index := 0
+		if len(q.SearchAfter) > 0 {
+			var cursor []int
+			if err := json.Unmarshal(q.SearchAfter, &cursor); err != nil {
+				t.Errorf("error decoding search_after: %v", err)
+			}
+			index = cursor[0]
+		}
+		if index >= len(pages) {
+			w.Header().Set("X-Result-Count", "0")
+			fmt.Fprint(w, "[]")
+			return
+		}
+		if index < len(pages)-1 {
+			w.Header().Set("X-Search_after", fmt.Sprintf("[%d]", index+1))
+		}
+		w.Header().Set("X-Result-Count", fmt.Sprintf("%d", len(pages[index])))
+		events := make([]string, len(pages[index]))
+		for i, id := range pages[index] {
+			events[i] = fmt.Sprintf(`{"service":"sso","id":"%v","timestamp":"2023-02-15T10:00:00Z"}`, id)
+		}
+		fmt.Fprint(w, "["+strings.Join(events, ",")+"]")
+	}))
+	return server, &queries
+}
+
+func TestJumpCloudAPI_GetEventsSinceTime(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		pages       [][]string
+		wantIDs     []string
+		wantQueries int
+	}{
+		{
+			name:        "TestGetEventsSinceTimeSinglePage",
+			limit:       2,
+			pages:       [][]string{{"a"}},
+			wantIDs:     []string{"a"},
+			wantQueries: 1,
+		},
+		{
+			name:        "TestGetEventsSinceTimeFollowsCursor",
+			limit:       2,
+			pages:       [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			wantIDs:     []string{"a", "b", "c", "d", "e"},
+			wantQueries: 3,
+		},
+		{
+			name:        "TestGetEventsSinceTimeFullLastPage",
+			limit:       2,
+			pages:       [][]string{{"a", "b"}, {"c", "d"}},
+			wantIDs:     []string{"a", "b", "c", "d"},
+			wantQueries: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, queries := pagedInsightsServer(t, tt.pages)
+			defer server.Close()
+			a := newTestJumpCloudAPI(NewJumpCloudAPIOptions{
+				APIKey:   "key",
+				BaseURL:  server.URL,
+				Services: []ServiceQuery{{Service: "sso", Limit: tt.limit}},
+			})
+			got, err := a.GetEventsSinceTime(time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Errorf("GetEventsSinceTime() error = %v", err)
+				return
+			}
+			var gotIDs []string
+			for _, x := range got.SSO {
+				gotIDs = append(gotIDs, x.ID)
+			}
+			if strings.Join(gotIDs, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("GetEventsSinceTime() got = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			if len(*queries) != tt.wantQueries {
+				t.Errorf("GetEventsSinceTime() queries = %v, want %v", len(*queries), tt.wantQueries)
+			}
+			for _, q := range *queries {
+				if q.EndTime != (*queries)[0].EndTime {
+					t.Errorf("GetEventsSinceTime() end_time changed between pages: %v != %v", q.EndTime, (*queries)[0].EndTime)
+				}
+			}
+		})
+	}
+}
+
+func TestJumpCloudAPI_GetEventsSinceTimePageError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Search_after", "[1]")
+		w.Header().Set("X-Result-Count", "1")
+		fmt.Fprint(w, `[{"service":"sso","id":"a"}]`)
+	}))
+	defer server.Close()
+	a := newTestJumpCloudAPI(NewJumpCloudAPIOptions{
+		APIKey:   "key",
+		BaseURL:  server.URL,
+		Services: []ServiceQuery{{Service: "sso", Limit: 1}},
+	})
+	got, err := a.GetEventsSinceTime(time.Now().Add(-time.Hour))
+	if err == nil {
+		t.Errorf("GetEventsSinceTime() expected an error when a later page fails, got = %v", got)
+	}
+}
+
+func Test_decodeJumpCloudEvents(t *testing.T) {
+	payload := []byte(`[
+		{"service":"systems","id":"sys-1","timestamp":"2023-02-15T10:00:00Z","event_type":"login_attempt","brand_new_field":{"nested":[1,2]}},
+		{"service":"quantum","id":"q-1","timestamp":"2023-02-15T10:00:01Z","spin":"up"},
+		{"service":"ldap","id":"ldap-1","timestamp":"2023-02-15T10:00:02Z","success":"not-a-bool"},
+		{"service":"directory","id":"dir-1","timestamp":"2023-02-15T10:00:03Z","initiated_by":{"type":"user","username":"jdoe","new_field":"x"},"geoip":{"country_code":"US","city":"Chicago"}}
+	]`)
+	tests := []struct {
+		name      string
+		rawEvents bool
+		want      []string
+	}{
+		{
+			name: "TestDecodeKeepsExtraAndUnknownFields",
+			want: []string{
+				`"brand_new_field":{"nested":[1,2]}`,
+				`"jumpcloud_event_type":"system"`,
+				`{"id":"q-1","jumpcloud_event_type":"quantum","service":"quantum","spin":"up","timestamp":"2023-02-15T10:00:01Z"}`,
+				`{"id":"ldap-1","jumpcloud_event_type":"ldap","service":"ldap","success":"not-a-bool","timestamp":"2023-02-15T10:00:02Z"}`,
+				`"initiated_by":{"new_field":"x","type":"user","username":"jdoe"}`,
+				`"geoip":{"city":"Chicago","country_code":"US"}`,
+			},
+		},
+		{
+			name:      "TestDecodeRawMode",
+			rawEvents: true,
+			want: []string{
+				`{"brand_new_field":{"nested":[1,2]},"event_type":"login_attempt","id":"sys-1","jumpcloud_event_type":"system","service":"systems","timestamp":"2023-02-15T10:00:00Z"}`,
+				`{"id":"q-1","jumpcloud_event_type":"quantum","service":"quantum","spin":"up","timestamp":"2023-02-15T10:00:01Z"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := decodeJumpCloudEvents(payload, tt.rawEvents)
+			if err != nil {
+				t.Fatalf("decodeJumpCloudEvents() error = %v", err)
+			}
+			all := events.allEvents()
+			if len(all) != 4 {
+				t.Errorf("decodeJumpCloudEvents() got %v events, want 4", len(all))
+			}
+			var lines []string
+			for _, x := range all {
+				payload, err := wazuhPayload(x)
+				if err != nil {
+					t.Fatalf("wazuhPayload() error = %v", err)
+				}
+				lines = append(lines, string(payload))
+			}
+			output := strings.Join(lines, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("decodeJumpCloudEvents() output = %v, want it to contain %v", output, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJumpCloudAPI_GetEventsSinceTimePerService(t *testing.T) {
+	var queries []insightsQuery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var q insightsQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Errorf("error decoding query: %v", err)
+		}
+		queries = append(queries, q)
+		fmt.Fprintf(w, `[{"service":"%v","id":"%v-1"}]`, q.Service[0], q.Service[0])
+	}))
+	defer server.Close()
+	a := newTestJumpCloudAPI(NewJumpCloudAPIOptions{
+		APIKey:   "key",
+		BaseURL:  server.URL,
+		Services: []ServiceQuery{{Service: "directory"}, {Service: "systems", Limit: 500}},
+	})
+	got, err := a.GetEventsSinceTime(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetEventsSinceTime() error = %v", err)
+	}
+	if len(got.Directory) != 1 || len(got.Systems) != 1 {
+		t.Errorf("GetEventsSinceTime() got = %+v, want one directory and one systems event", got)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("GetEventsSinceTime() queries = %v, want 2", len(queries))
+	}
+	if queries[0].Service[0] != "directory" || queries[0].Limit != insightsPageLimit {
+		t.Errorf("GetEventsSinceTime() first query = %+v, want directory with limit %v", queries[0], insightsPageLimit)
+	}
+	if queries[1].Service[0] != "systems" || queries[1].Limit != 500 {
+		t.Errorf("GetEventsSinceTime() second query = %+v, want systems with limit 500", queries[1])
+	}
+}
+
+func Test_decodeJumpCloudEventStream(t *testing.T) {
+	stop := errors.New("stop")
+	tests := []struct {
+		name        string
+		payload     string
+		stopAfter   int
+		wantIDs     []string
+		wantDecoded int
+		wantErr     bool
+	}{
+		{
+			name:        "TestDecodeStreamHandsEventsInOrder",
+			payload:     `[{"service":"sso","id":"a"},{"service":"quantum","id":"b"},{"service":"ldap","id":"c"}]`,
+			wantIDs:     []string{"a", "b", "c"},
+			wantDecoded: 3,
+		},
+		{
+			name:        "TestDecodeStreamEmpty",
+			payload:     `[]`,
+			wantDecoded: 0,
+		},
+		{
+			name:        "TestDecodeStreamStopsOnHandlerError",
+			payload:     `[{"service":"sso","id":"a"},{"service":"sso","id":"b"},{"service":"sso","id":"c"}]`,
+			stopAfter:   2,
+			wantIDs:     []string{"a", "b"},
+			wantDecoded: 2,
+			wantErr:     true,
+		},
+		{
+			name:        "TestDecodeStreamTruncated",
+			payload:     `[{"service":"sso","id":"a"},{"service":"sso","id":`,
+			wantIDs:     []string{"a"},
+			wantDecoded: 1,
+			wantErr:     true,
+		},
+		{
+			name:    "TestDecodeStreamNotAnArray",
+			payload: `{"message":"unauthorized"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotIDs []string
+			decoded, err := decodeJumpCloudEventStream(strings.NewReader(tt.payload), false, func(x JumpCloudEvent) error {
+				gotIDs = append(gotIDs, x.getID())
+				if len(gotIDs) == tt.stopAfter {
+					return stop
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeJumpCloudEventStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.stopAfter > 0 && !errors.Is(err, stop) {
+				t.Errorf("decodeJumpCloudEventStream() error = %v, want %v", err, stop)
+			}
+			if strings.Join(gotIDs, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("decodeJumpCloudEventStream() got = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			if decoded != tt.wantDecoded {
+				t.Errorf("decodeJumpCloudEventStream() decoded = %v, want %v", decoded, tt.wantDecoded)
+			}
+		})
+	}
+}
+
+// benchmarkPage returns a JSON array of n events spread over the modelled services, the size of a full Insights page
+func benchmarkPage(n int) []byte {
+	services := []string{"sso", "directory", "systems", "ldap", "radius", "admin"}
+	events := make([]string, n)
+	for i := range events {
+		events[i] = fmt.Sprintf(`{"service":"%v","id":"event-%v","timestamp":"2023-02-15T10:00:00Z","event_type":"user_login_attempt","success":true,`+
+			`"client_ip":"203.0.113.%v","initiated_by":{"id":"%v","type":"user","username":"user%v"},`+
+			`"geoip":{"country_code":"US","latitude":41.8483,"longitude":-87.6517,"region_name":"Illinois","timezone":"America/Chicago"},`+
+			`"useragent":{"name":"Chrome","os":"Mac OS X","version":"110.0.0.0","device":"Other"},"organization":"org-1","version":"1"}`,
+			services[i%len(services)], i, i%250, i, i)
+	}
+	return []byte("[" + strings.Join(events, ",") + "]")
+}
+
+// legacyDecodeJumpCloudEvents is the decode the streaming decoder replaced, kept to benchmark against.  It unmarshals
+// the whole page twice, into maps and into the base event, then marshals every map and unmarshals it again.  Every
+// event is then converted the way the baseline did, by marshalling the typed struct
+func legacyDecodeJumpCloudEvents(raw []byte) ([][]byte, error) {
+	generic := []map[string]interface{}{}
+	err := json.Unmarshal(raw, &generic)
+	if err != nil {
+		return nil, err
+	}
+	var events []BaseJumpCloudEvent
+	err = json.Unmarshal(raw, &events)
+	if err != nil {
+		return nil, err
+	}
+	var payloads [][]byte
+	for i, x := range events {
+		b, err := json.Marshal(generic[i])
+		if err != nil {
+			continue
+		}
+		if e, _ := newTypedEvent(x.Service); e != nil && json.Unmarshal(b, e) == nil {
+			payload, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads, nil
+}
+
+func BenchmarkDecodeLegacy(b *testing.B) {
+	page := benchmarkPage(insightsPageLimit)
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := legacyDecodeJumpCloudEvents(page)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeStream decodes a page and converts every event to the payload written to the outputs
+func BenchmarkDecodeStream(b *testing.B) {
+	page := benchmarkPage(insightsPageLimit)
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := decodeJumpCloudEventStream(bytes.NewReader(page), false, func(x JumpCloudEvent) error {
+			_, err := wazuhPayload(x)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the JumpCloud HTTP client when NewJumpCloudAPIOptions are left empty
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 2 * time.Minute
+	defaultMaxRetries     = 5
+	// retryBaseDelay is doubled on every attempt up to retryMaxDelay, a Retry-After from JumpCloud is honoured up
+	// to retryAfterMaxDelay
+	retryBaseDelay     = time.Second
+	retryMaxDelay      = 30 * time.Second
+	retryAfterMaxDelay = 5 * time.Minute
+)
+
+// Errors an APIError matches with errors.Is so callers can tell failures apart without looking at status codes
+var (
+	// ErrAuthFailed means JumpCloud rejected the API key or org ID, retrying will not help
+	ErrAuthFailed = errors.New("JumpCloud rejected the API key")
+	// ErrThrottled means the JumpCloud API rate limit was exceeded
+	ErrThrottled = errors.New("JumpCloud API rate limit exceeded")
+	// ErrServerError means JumpCloud failed to answer the request
+	ErrServerError = errors.New("JumpCloud API server error")
+)
+
+// APIError is returned when JumpCloud answers a request with an error status
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error response from JumpCloud: %v | %v | %v", e.Status, e.StatusCode, e.Body)
+}
+
+// Is matches the APIError against ErrAuthFailed, ErrThrottled and ErrServerError
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrAuthFailed:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrThrottled:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// retryable returns true for failures that may succeed when the request is sent again
+func (e *APIError) retryable() bool {
+	return e.Is(ErrThrottled) || e.Is(ErrServerError)
+}
+
+// newHTTPClient returns an HTTP client with separate limits for connecting and for waiting on JumpCloud to answer.
+// There is no overall timeout because the body is decoded while events are written, reading the body is limited by
+// readDeadlineBody instead
+func newHTTPClient(connectTimeout time.Duration, readTimeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   connectTimeout,
+			ResponseHeaderTimeout: readTimeout,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   2,
+		},
+	}
+}
+
+// postWithRetry sends a read only query to JumpCloud, retrying network failures, throttling and server errors with
+// exponential backoff and jitter.  Only use it for queries that are safe to send more than once.  The body of the
+// successful response is left unread so it can be decoded as it arrives, the caller must close it
+func (a *JumpCloudAPI) postWithRetry(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= a.maxRetries; attempt++ {
+		err := a.waitForRateLimit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, retryAfter, err := a.post(ctx, path, body)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		// A cancelled or expired context is not a JumpCloud failure, stop straight away
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !apiErr.retryable() {
+			return nil, err
+		}
+		if attempt == a.maxRetries {
+			break
+		}
+		delay := retryDelay(attempt, retryAfter, rand.Int63n)
+		logWarnf("JumpCloud request failed, retrying in %v (attempt %v of %v): %v", delay, attempt+1, a.maxRetries, err)
+		err = a.sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("giving up after %v attempts: %w", a.maxRetries+1, lastErr)
+}
+
+// post sends a single request and returns the response if JumpCloud answered with a 200, the caller must close its
+// body.  On failure it also returns how long JumpCloud asked us to wait if it said so
+func (a *JumpCloudAPI) post(ctx context.Context, path string, body []byte) (*http.Response, time.Duration, error) {
+	// The request has a context of its own so a read of the body that waits too long can abort it
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Add("x-api-key", a.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+	if a.orgID != "" {
+		req.Header.Add("x-org-id", a.orgID)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("error making request: %w", err)
+	}
+	res.Body = &readDeadlineBody{body: res.Body, timeout: a.readTimeout, cancel: cancel}
+	a.recordRateLimit(res.Header)
+	// JumpCloud API returns a 200 even if there are no events
+	if res.StatusCode == 200 {
+		return res, 0, nil
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading response body: %v | %v | %w", res.Status, res.StatusCode, err)
+	}
+	apiErr := &APIError{StatusCode: res.StatusCode, Status: res.Status, Body: string(resBody)}
+	return nil, parseRetryAfter(res.Header.Get("Retry-After"), time.Now()), apiErr
+}
+
+// errReadTimeout is returned when JumpCloud sends no data for longer than the read timeout
+var errReadTimeout = errors.New("timed out reading the response from JumpCloud")
+
+// readDeadlineBody limits how long a single read of a response body may wait on the network.  The time spent between
+// reads, such as writing the decoded events to slow outputs, does not count against it
+type readDeadlineBody struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	cancel   context.CancelFunc
+	timedOut atomic.Bool
+}
+
+func (b *readDeadlineBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, func() {
+		b.timedOut.Store(true)
+		b.cancel()
+	})
+	n, err := b.body.Read(p)
+	timer.Stop()
+	if err != nil && b.timedOut.Load() {
+		return n, fmt.Errorf("%w after waiting %v", errReadTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *readDeadlineBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// recordRateLimit remembers when the rate limit resets if JumpCloud says no requests are left, the next request
+// waits for the reset instead of being throttled
+func (a *JumpCloudAPI) recordRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil || remaining > 0 {
+		return
+	}
+	wait := parseRetryAfter(header.Get("X-RateLimit-Reset"), time.Now())
+	if wait > 0 {
+		a.rateLimitedUntil = time.Now().Add(wait)
+	}
+}
+
+func (a *JumpCloudAPI) waitForRateLimit(ctx context.Context) error {
+	wait := time.Until(a.rateLimitedUntil)
+	if wait <= 0 {
+		return nil
+	}
+	logInfof("JumpCloud API rate limit reached, waiting %v for it to reset", wait.Round(time.Second))
+	return a.sleep(ctx, wait)
+}
+
+// sleepContext waits for the duration or until the context is done, returning the context error if it is
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter reads a Retry-After or rate limit reset header, which is either a number of seconds, a unix time
+// or an HTTP date.  The wait is capped so a bad header can not stall the integration
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		wait = time.Duration(seconds) * time.Second
+		// Anything larger than a year of seconds is a unix time
+		if seconds > 365*24*60*60 {
+			wait = time.Unix(seconds, 0).Sub(now)
+		}
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > retryAfterMaxDelay {
+		return retryAfterMaxDelay
+	}
+	return wait
+}
+
+// retryDelay returns how long to wait before the next attempt, a Retry-After from JumpCloud wins over the
+// exponential backoff.  randInt63n is rand.Int63n and is only a parameter so tests can make the jitter predictable
+func retryDelay(attempt int, retryAfter time.Duration, randInt63n func(int64) int64) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := retryBaseDelay
+	for i := 0; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// Equal jitter, wait between half and all of the backoff so concurrent clients spread out
+	half := delay / 2
+	return half + time.Duration(randInt63n(int64(half)+1))
+}
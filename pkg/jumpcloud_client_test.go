@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJumpCloudAPI_postWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retryAfter   string
+		maxRetries   int
+		wantErr      error
+		wantRequests int
+		wantSleeps   []time.Duration
+	}{
+		{
+			name:         "TestPostWithRetryThrottledThenSuccess",
+			statuses:     []int{429, 429, 200},
+			retryAfter:   "7",
+			wantRequests: 3,
+			wantSleeps:   []time.Duration{7 * time.Second, 7 * time.Second},
+		},
+		{
+			name:         "TestPostWithRetryServerErrorThenSuccess",
+			statuses:     []int{503, 200},
+			wantRequests: 2,
+		},
+		{
+			name:         "TestPostWithRetryAuthFailureIsNotRetried",
+			statuses:     []int{401},
+			wantErr:      ErrAuthFailed,
+			wantRequests: 1,
+		},
+		{
+			name:         "TestPostWithRetryGivesUp",
+			statuses:     []int{500, 500, 500},
+			maxRetries:   2,
+			wantErr:      ErrServerError,
+			wantRequests: 3,
+		},
+		{
+			name:         "TestPostWithRetryStillThrottled",
+			statuses:     []int{429, 429},
+			maxRetries:   1,
+			wantErr:      ErrThrottled,
+			wantRequests: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[requests]
+				requests++
+				if tt.retryAfter != "" && status != 200 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				fmt.Fprint(w, "[]")
+			}))
+			defer server.Close()
+			a := NewJumpCloudAPI(NewJumpCloudAPIOptions{APIKey: "key", BaseURL: server.URL, MaxRetries: tt.maxRetries})
+			var sleeps []time.Duration
+			a.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+			res, err := a.postWithRetry(context.Background(), "/insights/directory/v1/events", []byte("{}"))
+			if err == nil {
+				res.Body.Close()
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("postWithRetry() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("postWithRetry() error = %v, want %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("postWithRetry() requests = %v, want %v", requests, tt.wantRequests)
+			}
+			if tt.wantSleeps != nil && fmt.Sprint(sleeps) != fmt.Sprint(tt.wantSleeps) {
+				t.Errorf("postWithRetry() sleeps = %v, want %v", sleeps, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestJumpCloudAPI_rateLimitReset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "30")
+		fmt.Fprint(w, "[]")
+	}))
+	defer server.Close()
+	a := NewJumpCloudAPI(NewJumpCloudAPIOptions{APIKey: "key", BaseURL: server.URL})
+	var sleeps []time.Duration
+	a.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		res, err := a.postWithRetry(context.Background(), "/insights/directory/v1/events", []byte("{}"))
+		if err != nil {
+			t.Fatalf("postWithRetry() error = %v", err)
+		}
+		res.Body.Close()
+	}
+	if len(sleeps) != 1 || sleeps[0] <= 25*time.Second || sleeps[0] > 30*time.Second {
+		t.Errorf("postWithRetry() sleeps = %v, want a single wait for the rate limit reset", sleeps)
+	}
+}
+
+func TestJumpCloudAPI_readTimeout(t *testing.T) {
+	page := `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00Z"},` +
+		`{"service":"sso","id":"sso-2","timestamp":"2023-02-15T10:00:01Z"},` +
+		`{"service":"sso","id":"sso-3","timestamp":"2023-02-15T10:00:02Z"}]`
+	tests := []struct {
+		name string
+		// stall stops the server after the first event until the test ends
+		stall       bool
+		handleDelay time.Duration
+		wantEvents  int
+		wantErr     error
+	}{
+		{name: "TestReadTimeoutSlowOutputs", handleDelay: 150 * time.Millisecond, wantEvents: 3},
+		{name: "TestReadTimeoutStalledResponse", stall: true, wantEvents: 1, wantErr: errReadTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.stall {
+					fmt.Fprint(w, page)
+					return
+				}
+				fmt.Fprint(w, page[:strings.Index(page, "},")+2])
+				w.(http.Flusher).Flush()
+				select {
+				case <-done:
+				case <-r.Context().Done():
+				}
+			}))
+			defer server.Close()
+			defer close(done)
+			a := NewJumpCloudAPI(NewJumpCloudAPIOptions{
+				APIKey:         "key",
+				BaseURL:        server.URL,
+				ConnectTimeout: 50 * time.Millisecond,
+				ReadTimeout:    50 * time.Millisecond,
+				MaxRetries:     -1,
+			})
+			events := 0
+			err := a.StreamServiceEventsSinceTime(context.Background(), AllServices, time.Now().Add(-time.Hour), func(JumpCloudEvent) error {
+				events++
+				time.Sleep(tt.handleDelay)
+				return nil
+			})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("StreamServiceEventsSinceTime() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("StreamServiceEventsSinceTime() error = %v, want %v", err, tt.wantErr)
+			}
+			if events != tt.wantEvents {
+				t.Errorf("StreamServiceEventsSinceTime() handled %v events, want %v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 2, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "TestParseRetryAfterEmpty", value: "", want: 0},
+		{name: "TestParseRetryAfterSeconds", value: "12", want: 12 * time.Second},
+		{name: "TestParseRetryAfterUnixTime", value: fmt.Sprint(now.Add(time.Minute).Unix()), want: time.Minute},
+		{name: "TestParseRetryAfterHTTPDate", value: now.Add(2 * time.Minute).Format(http.TimeFormat), want: 2 * time.Minute},
+		{name: "TestParseRetryAfterCapped", value: "86400", want: retryAfterMaxDelay},
+		{name: "TestParseRetryAfterPast", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "TestParseRetryAfterGarbage", value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	noJitter := func(int64) int64 { return 0 }
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "TestRetryDelayFirstAttempt", attempt: 0, want: retryBaseDelay / 2},
+		{name: "TestRetryDelayThirdAttempt", attempt: 2, want: 2 * retryBaseDelay},
+		{name: "TestRetryDelayCapped", attempt: 20, want: retryMaxDelay / 2},
+		{name: "TestRetryDelayRetryAfter", attempt: 3, retryAfter: 42 * time.Second, want: 42 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDelay(tt.attempt, tt.retryAfter, noJitter); got != tt.want {
+				t.Errorf("retryDelay() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
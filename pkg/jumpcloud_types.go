@@ -1,8 +1,11 @@
 package pkg
 
-import "time"
+import (
+	"time"
+)
 
 type JumpCloudLDAPEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	ErrorMessage       string `json:"error_message"`
 	InitiatedBy        struct {
@@ -42,6 +45,7 @@ type JumpCloudLDAPEvent struct {
 }
 
 type JumpCloudSystemEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	InitiatedBy        struct {
 		Type     string `json:"type"`
@@ -88,6 +92,7 @@ type JumpCloudSystemEvent struct {
 }
 
 type JumpCloudDirectoryEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	InitiatedBy        struct {
 		ID       string `json:"id"`
@@ -140,6 +145,7 @@ type JumpCloudDirectoryEvent struct {
 }
 
 type JumpCloudRadiusEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	InitiatedBy        struct {
 		Type     string `json:"type"`
@@ -186,6 +192,7 @@ type JumpCloudRadiusEvent struct {
 }
 
 type JumpCloudSSOEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	InitiatedBy        struct {
 		ID       string `json:"id"`
@@ -250,6 +257,7 @@ type JumpCloudSSOEvent struct {
 }
 
 type JumpCloudAdminEvent struct {
+	rawEvent
 	JumpCloudEventType string `json:"jumpcloud_event_type"`
 	InitiatedBy        struct {
 		ID    string `json:"id"`
@@ -280,3 +288,443 @@ type JumpCloudAdminEvent struct {
 	ID           string    `json:"id"`
 	Timestamp    time.Time `json:"timestamp"`
 }
+
+type JumpCloudMDMEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	System       struct {
+		Hostname    string `json:"hostname"`
+		DisplayName string `json:"displayName"`
+		ID          string `json:"id"`
+	} `json:"system,omitempty"`
+	Resource struct {
+		ID          string `json:"id"`
+		Type        string `json:"type"`
+		Hostname    string `json:"hostname"`
+		DisplayName string `json:"displayName"`
+	} `json:"resource,omitempty"`
+	Command struct {
+		Type        string `json:"type"`
+		UUID        string `json:"uuid"`
+		Status      string `json:"status"`
+		RequestType string `json:"request_type"`
+	} `json:"command,omitempty"`
+	DeviceSerialNumber string    `json:"device_serial_number,omitempty"`
+	EventType          string    `json:"event_type"`
+	Success            bool      `json:"success"`
+	Provider           any       `json:"provider"`
+	Service            string    `json:"service"`
+	Organization       string    `json:"organization"`
+	Version            string    `json:"@version"`
+	ID                 string    `json:"id"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+type JumpCloudPasswordManagerEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	Geoip        struct {
+		CountryCode   string  `json:"country_code"`
+		Timezone      string  `json:"timezone"`
+		Latitude      float64 `json:"latitude"`
+		ContinentCode string  `json:"continent_code"`
+		RegionName    string  `json:"region_name"`
+		Longitude     float64 `json:"longitude"`
+		RegionCode    string  `json:"region_code"`
+	} `json:"geoip,omitempty"`
+	Useragent struct {
+		Os        string `json:"os"`
+		Minor     string `json:"minor"`
+		OsMinor   string `json:"os_minor"`
+		OsMajor   string `json:"os_major"`
+		OsVersion string `json:"os_version"`
+		Version   string `json:"version"`
+		OsPatch   string `json:"os_patch"`
+		Patch     string `json:"patch"`
+		OsFull    string `json:"os_full"`
+		Major     string `json:"major"`
+		Name      string `json:"name"`
+		OsName    string `json:"os_name"`
+		Device    string `json:"device"`
+	} `json:"useragent,omitempty"`
+	Resource struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"resource,omitempty"`
+	Changes []struct {
+		Field string `json:"field"`
+	} `json:"changes,omitempty"`
+	EventType    string    `json:"event_type"`
+	Success      bool      `json:"success"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type JumpCloudSoftwareEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"initiated_by,omitempty"`
+	System struct {
+		Hostname    string `json:"hostname"`
+		DisplayName string `json:"displayName"`
+		ID          string `json:"id"`
+	} `json:"system,omitempty"`
+	Resource struct {
+		ID          string `json:"id"`
+		Type        string `json:"type"`
+		Hostname    string `json:"hostname"`
+		DisplayName string `json:"displayName"`
+	} `json:"resource,omitempty"`
+	Software struct {
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		BundleID string `json:"bundle_id"`
+		Path     string `json:"path"`
+	} `json:"software,omitempty"`
+	Changes []struct {
+		Field string `json:"field"`
+		From  any    `json:"from"`
+		To    any    `json:"to"`
+	} `json:"changes,omitempty"`
+	EventType    string    `json:"event_type"`
+	Provider     any       `json:"provider"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type JumpCloudAlertEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID    string `json:"id"`
+		Type  string `json:"type"`
+		Email string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	Alert struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		RuleID   string `json:"rule_id"`
+		Priority string `json:"priority"`
+		Status   string `json:"status"`
+	} `json:"alert,omitempty"`
+	Resource struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"resource,omitempty"`
+	Changes []struct {
+		Field string `json:"field"`
+	} `json:"changes,omitempty"`
+	EventType    string    `json:"event_type"`
+	Provider     any       `json:"provider"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type JumpCloudObjectStorageEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	Geoip        struct {
+		CountryCode   string  `json:"country_code"`
+		Timezone      string  `json:"timezone"`
+		Latitude      float64 `json:"latitude"`
+		ContinentCode string  `json:"continent_code"`
+		RegionName    string  `json:"region_name"`
+		Longitude     float64 `json:"longitude"`
+		RegionCode    string  `json:"region_code"`
+	} `json:"geoip,omitempty"`
+	Resource struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"resource,omitempty"`
+	EventType    string    `json:"event_type"`
+	Success      bool      `json:"success"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type JumpCloudSaaSAppManagementEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	Geoip struct {
+		CountryCode   string  `json:"country_code"`
+		Timezone      string  `json:"timezone"`
+		Latitude      float64 `json:"latitude"`
+		ContinentCode string  `json:"continent_code"`
+		RegionName    string  `json:"region_name"`
+		Longitude     float64 `json:"longitude"`
+		RegionCode    string  `json:"region_code"`
+	} `json:"geoip,omitempty"`
+	Useragent struct {
+		Os        string `json:"os"`
+		Minor     string `json:"minor"`
+		OsMinor   string `json:"os_minor"`
+		OsMajor   string `json:"os_major"`
+		OsVersion string `json:"os_version"`
+		Version   string `json:"version"`
+		OsPatch   string `json:"os_patch"`
+		Patch     string `json:"patch"`
+		OsFull    string `json:"os_full"`
+		Major     string `json:"major"`
+		Name      string `json:"name"`
+		OsName    string `json:"os_name"`
+		Device    string `json:"device"`
+	} `json:"useragent,omitempty"`
+	Application struct {
+		ID           string `json:"id"`
+		Name         string `json:"name"`
+		CatalogAppID string `json:"catalog_app_id"`
+		Status       string `json:"status"`
+	} `json:"application,omitempty"`
+	Resource struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"resource,omitempty"`
+	Changes []struct {
+		Field string `json:"field"`
+	} `json:"changes,omitempty"`
+	EventType    string    `json:"event_type"`
+	Success      bool      `json:"success"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type JumpCloudAccessManagementEvent struct {
+	rawEvent
+	JumpCloudEventType string `json:"jumpcloud_event_type"`
+	InitiatedBy        struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	} `json:"initiated_by,omitempty"`
+	Geoip struct {
+		CountryCode   string  `json:"country_code"`
+		Timezone      string  `json:"timezone"`
+		Latitude      float64 `json:"latitude"`
+		ContinentCode string  `json:"continent_code"`
+		RegionName    string  `json:"region_name"`
+		Longitude     float64 `json:"longitude"`
+		RegionCode    string  `json:"region_code"`
+	} `json:"geoip,omitempty"`
+	Resource struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"resource,omitempty"`
+	AccessRequest struct {
+		ID         string `json:"id"`
+		Status     string `json:"status"`
+		Reason     string `json:"reason"`
+		Duration   string `json:"duration"`
+		ApprovedBy string `json:"approved_by"`
+	} `json:"access_request,omitempty"`
+	EventType    string    `json:"event_type"`
+	Success      bool      `json:"success"`
+	Service      string    `json:"service"`
+	Organization string    `json:"organization"`
+	Version      string    `json:"@version"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// JumpCloudRawEvent is an event kept exactly as JumpCloud sent it.  It is used for services that are not modelled
+// and for every event when raw mode is enabled so a schema change never loses data
+type JumpCloudRawEvent struct {
+	rawEvent
+	JumpCloudEventType string
+	Service            string
+	ID                 string
+	Timestamp          time.Time
+}
+
+// newJumpCloudRawEvent wraps the original JSON object of an event from the given service
+func newJumpCloudRawEvent(service string, original eventDocument) JumpCloudRawEvent {
+	e := JumpCloudRawEvent{
+		JumpCloudEventType: jumpCloudEventTypeForService(service),
+		Service:            service,
+		ID:                 original.str("id"),
+	}
+	e.setOriginal(original)
+	// A missing or malformed timestamp leaves the zero time so the event is still emitted
+	e.Timestamp, _ = time.Parse(time.RFC3339Nano, original.str("timestamp"))
+	return e
+}
+
+// jumpCloudEventTypeForService returns the jumpcloud_event_type used for events from a JumpCloud service, these
+// match the values set by the typed events so rules work the same in raw mode
+func jumpCloudEventTypeForService(service string) string {
+	switch service {
+	case "systems":
+		return "system"
+	case "":
+		return "unknown"
+	}
+	return service
+}
+
+// getTimestamp returns the time JumpCloud recorded the event
+func (d *JumpCloudLDAPEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudSystemEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudDirectoryEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudRadiusEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudSSOEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudAdminEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudMDMEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudPasswordManagerEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudSoftwareEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudAlertEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudObjectStorageEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudSaaSAppManagementEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudAccessManagementEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+func (d *JumpCloudRawEvent) getTimestamp() time.Time {
+	return d.Timestamp
+}
+
+// getID returns the unique ID JumpCloud assigned to the event
+func (d *JumpCloudLDAPEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudSystemEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudDirectoryEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudRadiusEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudSSOEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudAdminEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudMDMEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudPasswordManagerEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudSoftwareEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudAlertEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudObjectStorageEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudSaaSAppManagementEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudAccessManagementEvent) getID() string {
+	return d.ID
+}
+
+func (d *JumpCloudRawEvent) getID() string {
+	return d.ID
+}
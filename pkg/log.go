@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel controls which messages the integration prints
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the LogLevel with the given name, one of debug, info, warn or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LogLevelInfo, fmt.Errorf("unknown log level %q, expected one of debug, info, warn or error", name)
+}
+
+var (
+	logMu    sync.Mutex
+	logLevel = LogLevelInfo
+	// logOutput is stderr so log lines never mix with events written to stdout
+	logOutput io.Writer = os.Stderr
+)
+
+// SetLogLevel sets the lowest level of message that is printed
+func SetLogLevel(level LogLevel) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logLevel = level
+}
+
+// logf prints a message to stderr if level is at or above the configured log level, Wazuh captures stdout and stderr
+// of the command wodle in ossec.log
+func logf(level LogLevel, format string, args ...interface{}) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if level < logLevel {
+		return
+	}
+	fmt.Fprintf(logOutput, "%v %v: %v\n", time.Now().UTC().Format(time.RFC3339), level, fmt.Sprintf(format, args...))
+}
+
+func logDebugf(format string, args ...interface{}) {
+	logf(LogLevelDebug, format, args...)
+}
+
+func logInfof(format string, args ...interface{}) {
+	logf(LogLevelInfo, format, args...)
+}
+
+func logWarnf(format string, args ...interface{}) {
+	logf(LogLevelWarn, format, args...)
+}
+
+func logErrorf(format string, args ...interface{}) {
+	logf(LogLevelError, format, args...)
+}
@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Actions a redaction rule can take on a field
+const (
+	// RedactDrop removes the field
+	RedactDrop = "drop"
+	// RedactMask hides the field, strings are replaced by asterisks except the network of an IP address and the
+	// domain of an email address, other values are removed
+	RedactMask = "mask"
+	// RedactHMAC replaces the field by a keyed hash so the same value always has the same pseudonym
+	RedactHMAC = "hmac"
+)
+
+// Masks written by the mask action
+const (
+	maskedValue = "****"
+	// minRedactionKey is the shortest HMAC key accepted, in bytes
+	minRedactionKey = 16
+	// pseudonymLength is how many hex characters of the HMAC are kept
+	pseudonymLength = 32
+)
+
+// RedactionConfig hides or pseudonymizes fields of events before they are sent to any output
+type RedactionConfig struct {
+	// Key or KeyFile is the tenant's secret HMAC key, needed by hmac rules.  Pseudonyms only match between
+	// collectors that use the same key
+	Key     string `json:"key,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+	// Rules are applied in order to every event
+	Rules []RedactionRule `json:"rules"`
+}
+
+// RedactionRule applies an action to fields of the events of some services
+type RedactionRule struct {
+	// Services the rule applies to, every service when empty
+	Services []string `json:"services,omitempty"`
+	// Fields are dotted paths such as initiated_by.email, a path to an object applies the action to everything in it
+	// and lists are applied to element by element
+	Fields []string `json:"fields"`
+	// Action is drop, mask or hmac
+	Action string `json:"action"`
+}
+
+// needsKey returns true if any rule pseudonymizes fields
+func (c RedactionConfig) needsKey() bool {
+	for _, x := range c.Rules {
+		if x.Action == RedactHMAC {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks every rule can be applied
+func (c RedactionConfig) validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("redaction has no rules")
+	}
+	for i, x := range c.Rules {
+		switch x.Action {
+		case RedactDrop, RedactMask, RedactHMAC:
+		default:
+			return fmt.Errorf("redaction rule %v has unknown action %q, expected one of drop, mask or hmac", i+1, x.Action)
+		}
+		if len(x.Fields) == 0 {
+			return fmt.Errorf("redaction rule %v has no fields", i+1)
+		}
+		for _, field := range x.Fields {
+			if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+				return fmt.Errorf("redaction rule %v has invalid field %q", i+1, field)
+			}
+		}
+	}
+	if c.Key != "" && c.KeyFile != "" {
+		return fmt.Errorf("redaction can have a key or a key_file, not both")
+	}
+	if c.needsKey() && c.Key == "" && c.KeyFile == "" {
+		return fmt.Errorf("redaction hmac rules need a key or key_file")
+	}
+	if c.Key != "" && len(c.Key) < minRedactionKey {
+		return fmt.Errorf("redaction key must be at least %v bytes", minRedactionKey)
+	}
+	return nil
+}
+
+// Redactor applies redaction rules to events
+type Redactor struct {
+	rules []RedactionRule
+	key   []byte
+}
+
+// NewRedactor returns a redactor for the config, reading the key file if it has one
+func NewRedactor(c RedactionConfig) (*Redactor, error) {
+	err := c.validate()
+	if err != nil {
+		return nil, err
+	}
+	r := &Redactor{rules: c.Rules, key: []byte(c.Key)}
+	if c.KeyFile != "" {
+		key, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading redaction key: %w", err)
+		}
+		r.key = bytes.TrimSpace(key)
+		if len(r.key) < minRedactionKey {
+			return nil, fmt.Errorf("redaction key in %v must be at least %v bytes", c.KeyFile, minRedactionKey)
+		}
+	}
+	return r, nil
+}
+
+// Redact returns the event payload with the rules of its service applied, payload is returned unchanged if no rule
+// applies to the service
+func (r *Redactor) Redact(payload []byte) ([]byte, error) {
+	event, err := decodeEventDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !r.redact(event) {
+		return payload, nil
+	}
+	return event.encode()
+}
+
+// redact applies the rules of the event's service to it in place, it returns false if no rule applies
+func (r *Redactor) redact(event eventDocument) bool {
+	service := event.str("service")
+	changed := false
+	for _, rule := range r.rules {
+		if !rule.appliesTo(service) {
+			continue
+		}
+		changed = true
+		for _, field := range rule.Fields {
+			r.apply(map[string]interface{}(event), strings.Split(field, "."), rule.Action)
+		}
+	}
+	return changed
+}
+
+func (x RedactionRule) appliesTo(service string) bool {
+	if len(x.Services) == 0 {
+		return true
+	}
+	for _, s := range x.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies action to the field at path below object, lists on the way are applied to element by element
+func (r *Redactor) apply(object map[string]interface{}, path []string, action string) {
+	value, ok := object[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) > 1 {
+		r.applyBelow(value, path[1:], action)
+		return
+	}
+	if action == RedactDrop {
+		delete(object, path[0])
+		return
+	}
+	redacted, keep := r.redactValue(value, action)
+	if !keep {
+		delete(object, path[0])
+		return
+	}
+	object[path[0]] = redacted
+}
+
+func (r *Redactor) applyBelow(value interface{}, path []string, action string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		r.apply(v, path, action)
+	case []interface{}:
+		for _, element := range v {
+			r.applyBelow(element, path, action)
+		}
+	}
+}
+
+// redactValue masks or pseudonymizes a value and everything in it, it returns false if the value should be removed
+func (r *Redactor) redactValue(value interface{}, action string) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, true
+	case map[string]interface{}:
+		for key, x := range v {
+			redacted, keep := r.redactValue(x, action)
+			if keep {
+				v[key] = redacted
+			} else {
+				delete(v, key)
+			}
+		}
+		return v, true
+	case []interface{}:
+		var redacted []interface{}
+		for _, x := range v {
+			if y, keep := r.redactValue(x, action); keep {
+				redacted = append(redacted, y)
+			}
+		}
+		return redacted, true
+	case string:
+		if v == "" {
+			return v, true
+		}
+		if action == RedactHMAC {
+			return r.pseudonym(v), true
+		}
+		return mask(v), true
+	}
+	// Numbers and booleans can be pseudonymized as text but a mask could only hide them by removing them
+	if action == RedactHMAC {
+		return r.pseudonym(fmt.Sprint(value)), true
+	}
+	return nil, false
+}
+
+// pseudonym returns the hex HMAC-SHA256 of value, the same value always gives the same pseudonym for a key
+func (r *Redactor) pseudonym(value string) string {
+	h := hmac.New(sha256.New, r.key)
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))[:pseudonymLength]
+}
+
+// mask hides a string, an IPv4 address keeps its /24 network, an IPv6 address its /48 network and an email address
+// its domain so events can still be grouped by them
+func mask(value string) string {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+	if at := strings.LastIndex(value, "@"); at > 0 {
+		return maskedValue + value[at:]
+	}
+	return maskedValue
+}
+
+// redactingSink redacts every event before it is written to another sink
+type redactingSink struct {
+	redactor *Redactor
+	sink     EventSink
+}
+
+// NewRedactingSink returns a sink that redacts every event and writes it to sink, or sink itself if redactor is nil
+func NewRedactingSink(sink EventSink, redactor *Redactor) EventSink {
+	if redactor == nil {
+		return sink
+	}
+	return &redactingSink{redactor: redactor, sink: sink}
+}
+
+func (s *redactingSink) WriteEvent(payload []byte) error {
+	redacted, err := s.redactor.Redact(payload)
+	if err != nil {
+		return err
+	}
+	return s.sink.WriteEvent(redacted)
+}
+
+func (s *redactingSink) writeDocument(event eventDocument) error {
+	s.redactor.redact(event)
+	return writeDocument(s.sink, event)
+}
+
+func (s *redactingSink) Flush() error {
+	return s.sink.Flush()
+}
+
+func (s *redactingSink) Close() error {
+	return s.sink.Close()
+}
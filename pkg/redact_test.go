@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRedactionKey = "0123456789abcdef-tenant-a"
+
+func TestRedactor_Redact(t *testing.T) {
+	event := `{"service":"directory","event_type":"user_login_attempt","client_ip":"203.0.113.9","organization":"org-1",` +
+		`"initiated_by":{"type":"user","username":"jdoe","email":"jdoe@example.com"},"geoip":{"country_code":"US","latitude":41.85},` +
+		`"changes":[{"field":"email","from":"old@example.com","to":"new@example.com"}]}`
+	pseudonym := (&Redactor{key: []byte(testRedactionKey)}).pseudonym("jdoe")
+	tests := []struct {
+		name  string
+		rules []RedactionRule
+		want  map[string]interface{}
+	}{
+		{
+			name:  "TestRedactDropObject",
+			rules: []RedactionRule{{Fields: []string{"geoip", "missing.field"}, Action: RedactDrop}},
+			want:  map[string]interface{}{"geoip": nil, "client_ip": "203.0.113.9"},
+		},
+		{
+			name:  "TestRedactMask",
+			rules: []RedactionRule{{Fields: []string{"client_ip", "initiated_by.email", "initiated_by.username", "geoip.latitude"}, Action: RedactMask}},
+			want: map[string]interface{}{
+				"client_ip":             "203.0.113.0",
+				"initiated_by.email":    "****@example.com",
+				"initiated_by.username": "****",
+				"initiated_by.type":     "user",
+				"geoip.latitude":        nil,
+				"geoip.country_code":    "US",
+			},
+		},
+		{
+			name:  "TestRedactHMAC",
+			rules: []RedactionRule{{Fields: []string{"initiated_by.username"}, Action: RedactHMAC}},
+			want:  map[string]interface{}{"initiated_by.username": pseudonym, "initiated_by.email": "jdoe@example.com"},
+		},
+		{
+			name:  "TestRedactList",
+			rules: []RedactionRule{{Fields: []string{"changes.from", "changes.to"}, Action: RedactMask}},
+			want:  map[string]interface{}{"changes": "[map[field:email from:****@example.com to:****@example.com]]"},
+		},
+		{
+			name:  "TestRedactOtherService",
+			rules: []RedactionRule{{Services: []string{"sso", "radius"}, Fields: []string{"client_ip"}, Action: RedactDrop}},
+			want:  map[string]interface{}{"client_ip": "203.0.113.9"},
+		},
+		{
+			name: "TestRedactRulesInOrder",
+			rules: []RedactionRule{
+				{Services: []string{"directory"}, Fields: []string{"initiated_by"}, Action: RedactHMAC},
+				{Fields: []string{"initiated_by.type"}, Action: RedactDrop},
+			},
+			want: map[string]interface{}{"initiated_by.username": pseudonym, "initiated_by.type": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(RedactionConfig{Key: testRedactionKey, Rules: tt.rules})
+			if err != nil {
+				t.Fatalf("NewRedactor() error = %v", err)
+			}
+			b, err := r.Redact([]byte(event))
+			if err != nil {
+				t.Fatalf("Redact() error = %v", err)
+			}
+			got, err := decodeEventDocument(b)
+			if err != nil {
+				t.Fatalf("Redact() did not return JSON: %v", err)
+			}
+			for path, want := range tt.want {
+				value := got.get(path)
+				if want == nil {
+					if value != nil {
+						t.Errorf("Redact() %v got = %v, want it removed", path, value)
+					}
+					continue
+				}
+				if fmt.Sprint(value) != want {
+					t.Errorf("Redact() %v got = %v, want %v", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactor_pseudonym(t *testing.T) {
+	a := &Redactor{key: []byte(testRedactionKey)}
+	b := &Redactor{key: []byte("another-tenant-key-0123")}
+	if a.pseudonym("jdoe") != a.pseudonym("jdoe") {
+		t.Errorf("pseudonym() is not stable for the same key")
+	}
+	if a.pseudonym("jdoe") == a.pseudonym("jsmith") {
+		t.Errorf("pseudonym() is the same for different values")
+	}
+	if a.pseudonym("jdoe") == b.pseudonym("jdoe") {
+		t.Errorf("pseudonym() is the same for different tenant keys")
+	}
+	if got := len(a.pseudonym("jdoe")); got != pseudonymLength {
+		t.Errorf("pseudonym() got length %v, want %v", got, pseudonymLength)
+	}
+}
+
+func TestRedactionConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RedactionConfig
+		wantErr bool
+	}{
+		{name: "TestRedactionValid", config: RedactionConfig{Key: testRedactionKey, Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactHMAC}}}},
+		{name: "TestRedactionDropNeedsNoKey", config: RedactionConfig{Rules: []RedactionRule{{Fields: []string{"geoip"}, Action: RedactDrop}}}},
+		{name: "TestRedactionNoRules", config: RedactionConfig{Key: testRedactionKey}, wantErr: true},
+		{name: "TestRedactionUnknownAction", config: RedactionConfig{Rules: []RedactionRule{{Fields: []string{"geoip"}, Action: "hash"}}}, wantErr: true},
+		{name: "TestRedactionNoFields", config: RedactionConfig{Rules: []RedactionRule{{Action: RedactDrop}}}, wantErr: true},
+		{name: "TestRedactionBadField", config: RedactionConfig{Rules: []RedactionRule{{Fields: []string{"geoip..latitude"}, Action: RedactDrop}}}, wantErr: true},
+		{name: "TestRedactionHMACNeedsKey", config: RedactionConfig{Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactHMAC}}}, wantErr: true},
+		{name: "TestRedactionShortKey", config: RedactionConfig{Key: "short", Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactHMAC}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRedactorKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redaction.key")
+	err := os.WriteFile(path, []byte(testRedactionKey+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRedactor(RedactionConfig{KeyFile: path, Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactHMAC}}})
+	if err != nil {
+		t.Fatalf("NewRedactor() error = %v", err)
+	}
+	if string(r.key) != testRedactionKey {
+		t.Errorf("NewRedactor() got key %q, want %q without the trailing newline", r.key, testRedactionKey)
+	}
+	_, err = NewRedactor(RedactionConfig{KeyFile: filepath.Join(t.TempDir(), "missing"), Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactHMAC}}})
+	if err == nil {
+		t.Errorf("NewRedactor() error = nil, want an error for a missing key file")
+	}
+}
+
+func TestRedactingSink(t *testing.T) {
+	out := &recordingSink{}
+	r, err := NewRedactor(RedactionConfig{Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactDrop}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NewRedactingSink(out, nil) != EventSink(out) {
+		t.Errorf("NewRedactingSink() wrapped a sink without a redactor")
+	}
+	err = NewRedactingSink(out, r).WriteEvent([]byte(`{"service":"sso","client_ip":"203.0.113.9"}`))
+	if err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	if len(out.events) != 1 || strings.Contains(out.events[0], "203.0.113.9") {
+		t.Errorf("WriteEvent() got = %v, want the client_ip dropped", out.events)
+	}
+}
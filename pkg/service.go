@@ -1,76 +1,208 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"time"
 )
 
+// TimeTracker keeps the checkpoint each service has been collected up to.  The last time is used when all services
+// are collected in a single query, otherwise every service has its own checkpoint so a service whose events arrive
+// late does not lose them to another service's newer events
 type TimeTracker interface {
 	UpdateLast(newTime time.Time) error
 	GetLastTime() time.Time
+	UpdateServiceLast(service string, newTime time.Time) error
+	GetServiceLastTime(service string) time.Time
+	// Overlap is how far before a checkpoint to query again so events JumpCloud ingests late are not missed
+	Overlap() time.Duration
+	// SeenEvent returns true if the event with the given ID was already emitted for the service
+	SeenEvent(service string, id string) bool
+	// MarkSeen records an emitted event, it must be persisted with the next checkpoint update of the service
+	MarkSeen(service string, id string, timestamp time.Time)
 }
 
 type JumpCloudConnector interface {
-	GetEventsSinceTime(time.Time) (*JumpCloudEvents, error)
+	// Services returns the services to collect, each one is queried and checkpointed separately
+	Services() []string
+	// StreamServiceEventsSinceTime hands the events of a service since startTime to handle one at a time, oldest
+	// first.  It must stop and return the error if handle returns one, and the context error once ctx is done
+	StreamServiceEventsSinceTime(ctx context.Context, service string, startTime time.Time, handle func(JumpCloudEvent) error) error
 }
 
 // RunService is the main entry point for the service it will run a single time and return an error if one is encountered
 func RunService(timeTracker TimeTracker, j JumpCloudConnector, pathToLogFile string) error {
-	f, err := os.OpenFile(pathToLogFile,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return RunServiceContext(context.Background(), timeTracker, j, pathToLogFile)
+}
+
+// RunServiceContext is RunService with a context.  Once ctx is done no more queries are sent and no more events are
+// written, the checkpoint is still moved up to the last event that was written so nothing is lost or repeated
+func RunServiceContext(ctx context.Context, timeTracker TimeTracker, j JumpCloudConnector, pathToLogFile string) error {
+	sink, err := NewFileSink(pathToLogFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	lastTime := timeTracker.GetLastTime()
-	e, err := j.GetEventsSinceTime(lastTime)
-	if err != nil {
-		return err
+	defer sink.Close()
+	return RunServiceToSinkContext(ctx, timeTracker, j, sink)
+}
+
+// RunServiceToWriter runs the service a single time writing events to w instead of a log file
+func RunServiceToWriter(timeTracker TimeTracker, j JumpCloudConnector, w io.Writer) error {
+	return RunServiceToWriterContext(context.Background(), timeTracker, j, w)
+}
+
+// RunServiceToWriterContext is RunServiceToWriter with a context, see RunServiceContext
+func RunServiceToWriterContext(ctx context.Context, timeTracker TimeTracker, j JumpCloudConnector, w io.Writer) error {
+	return RunServiceToSinkContext(ctx, timeTracker, j, NewWriterSink(w))
+}
+
+// RunServiceToSinkContext runs the service a single time sending events to sink, the checkpoint of each service is
+// only moved once the sink confirms its events.  See RunServiceContext
+func RunServiceToSinkContext(ctx context.Context, timeTracker TimeTracker, j JumpCloudConnector, sink EventSink) error {
+	for _, service := range j.Services() {
+		err := runServiceQuery(ctx, timeTracker, j, service, sink)
+		if err != nil {
+			return fmt.Errorf("error collecting %v events: %w", service, err)
+		}
 	}
-	// Before doing anything make sure there is at least one event, if there isn't we don't need to do anything
-	if len(e.Directory) == 0 && len(e.LDAP) == 0 && len(e.Systems) == 0 && len(e.SSO) == 0 && len(e.Radius) == 0 {
-		return nil
+	return nil
+}
+
+// runServiceQuery collects the events of a single service since its checkpoint, writes them to the sink as they
+// arrive and moves the checkpoint for that service forward once the sink confirms them.  The overlap window before the
+// checkpoint is queried again and events that were already emitted are skipped by ID.  If the query fails part way
+// the checkpoint still covers the events that were written
+func runServiceQuery(ctx context.Context, timeTracker TimeTracker, j JumpCloudConnector, service string, sink EventSink) error {
+	lastTime := timeTracker.GetServiceLastTime(service)
+	if service == AllServices {
+		lastTime = timeTracker.GetLastTime()
 	}
 	lastEventSeen := lastTime
-	// Loop over all events and find the newest timestamp, we will use this to update the last time we ran the service
-	for _, x := range e.Directory {
-		if x.Timestamp.After(lastEventSeen) {
-			lastEventSeen = x.Timestamp
+	written := 0
+	filtered := 0
+	// A failed write does not stop the run, but the checkpoint stays before the first failed event so it is
+	// collected again next run.  Events written after it are marked as seen so they are not repeated
+	failed := 0
+	var writeErr error
+	// Events are only marked as seen once the sink confirms them, otherwise a failed run would skip them next time
+	pending := map[string]time.Time{}
+	// Track the newest timestamp written, we will use this to update the last time we ran the service.  Events arrive
+	// oldest first so stopping part way leaves a checkpoint with nothing older left unwritten
+	err := j.StreamServiceEventsSinceTime(ctx, service, lastTime.Add(-timeTracker.Overlap()), func(x JumpCloudEvent) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		_, writeErr := f.WriteString(x.convertToWazuhString() + "\n")
-		if writeErr != nil {
-			fmt.Printf("Error writing to file: %s", writeErr.Error())
+		if x.getID() != "" {
+			if _, ok := pending[x.getID()]; ok || timeTracker.SeenEvent(service, x.getID()) {
+				// Events written by an earlier run after a failed write can be checkpointed once nothing before them
+				// is missing
+				if failed == 0 && x.getTimestamp().After(lastEventSeen) {
+					lastEventSeen = x.getTimestamp()
+				}
+				return nil
+			}
 		}
-	}
-	for _, x := range e.LDAP {
-		if x.Timestamp.After(lastEventSeen) {
-			lastEventSeen = x.Timestamp
+		err := writeDocument(sink, x.wazuhDocument())
+		if errors.Is(err, ErrEventFiltered) {
+			// Dropped events are checkpointed and skipped next run like written ones, but are not counted as written
+			filtered++
+			err = nil
+		} else if err == nil {
+			written++
 		}
-		_, writeErr := f.WriteString(x.convertToWazuhString() + "\n")
-		if writeErr != nil {
-			fmt.Printf("Error writing to file: %s", writeErr.Error())
+		if err != nil {
+			failed++
+			if writeErr == nil {
+				writeErr = err
+				logErrorf("Error writing %v event, the checkpoint will not move past it: %s", service, err.Error())
+			}
+			return nil
 		}
-	}
-	for _, x := range e.Systems {
-		if x.Timestamp.After(lastEventSeen) {
-			lastEventSeen = x.Timestamp
+		if x.getID() != "" {
+			pending[x.getID()] = x.getTimestamp()
 		}
-		_, writeErr := f.WriteString(x.convertToWazuhString() + "\n")
-		if writeErr != nil {
-			fmt.Printf("Error writing to file: %s", writeErr.Error())
+		if failed == 0 && x.getTimestamp().After(lastEventSeen) {
+			lastEventSeen = x.getTimestamp()
 		}
+		return nil
+	})
+	logInfof("Wrote %v new %v events", written, service)
+	// Make sure the events are on disk before the checkpoint says they were written
+	flushErr := sink.Flush()
+	if flushErr != nil {
+		return fmt.Errorf("events were not confirmed by every required output, the checkpoint was not moved: %w", flushErr)
 	}
-	for _, x := range e.SSO {
-		if x.Timestamp.After(lastEventSeen) {
-			lastEventSeen = x.Timestamp
+	// If every event was already emitted there is nothing new to checkpoint
+	if written+filtered > 0 || lastEventSeen.After(lastTime) {
+		for id, ts := range pending {
+			timeTracker.MarkSeen(service, id, ts)
+		}
+		var checkpointErr error
+		if service == AllServices {
+			checkpointErr = timeTracker.UpdateLast(lastEventSeen)
+		} else {
+			checkpointErr = timeTracker.UpdateServiceLast(service, lastEventSeen)
 		}
-		_, writeErr := f.WriteString(x.convertToWazuhString() + "\n")
-		if writeErr != nil {
-			fmt.Printf("Error writing to file: %s", writeErr.Error())
+		if checkpointErr != nil {
+			return checkpointErr
 		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v %v events could not be written: %w", failed, failed+written+filtered, service, writeErr)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// readOnlyTimeTracker reads checkpoints from another TimeTracker but keeps every change in memory, so a dry run in
+// daemon mode moves forward between runs without writing the state file
+type readOnlyTimeTracker struct {
+	TimeTracker
+	last        *time.Time
+	checkpoints map[string]time.Time
+	seen        map[string]map[string]bool
+}
+
+// ReadOnlyTimeTracker wraps a TimeTracker so checkpoints are read but never written, used for dry runs
+func ReadOnlyTimeTracker(t TimeTracker) TimeTracker {
+	return &readOnlyTimeTracker{TimeTracker: t, checkpoints: map[string]time.Time{}, seen: map[string]map[string]bool{}}
+}
+
+func (r *readOnlyTimeTracker) UpdateLast(newTime time.Time) error {
+	r.last = &newTime
+	return nil
+}
+
+func (r *readOnlyTimeTracker) GetLastTime() time.Time {
+	if r.last != nil {
+		return *r.last
+	}
+	return r.TimeTracker.GetLastTime()
+}
+
+func (r *readOnlyTimeTracker) UpdateServiceLast(service string, newTime time.Time) error {
+	r.checkpoints[service] = newTime
+	return nil
+}
+
+func (r *readOnlyTimeTracker) GetServiceLastTime(service string) time.Time {
+	if last, ok := r.checkpoints[service]; ok {
+		return last
+	}
+	return r.TimeTracker.GetServiceLastTime(service)
+}
+
+func (r *readOnlyTimeTracker) SeenEvent(service string, id string) bool {
+	return r.seen[service][id] || r.TimeTracker.SeenEvent(service, id)
+}
 
+func (r *readOnlyTimeTracker) MarkSeen(service string, id string, _ time.Time) {
+	if r.seen[service] == nil {
+		r.seen[service] = map[string]bool{}
 	}
-	err = timeTracker.UpdateLast(lastEventSeen.Add(time.Second * 1))
-	return err
+	r.seen[service][id] = true
 }
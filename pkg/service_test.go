@@ -0,0 +1,427 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryTimeTracker is a TimeTracker that keeps the last time in memory
+type memoryTimeTracker struct {
+	last        time.Time
+	updated     bool
+	checkpoints map[string]time.Time
+	overlap     time.Duration
+	seen        map[string]bool
+}
+
+func (m *memoryTimeTracker) UpdateLast(newTime time.Time) error {
+	m.last = newTime
+	m.updated = true
+	return nil
+}
+
+func (m *memoryTimeTracker) GetLastTime() time.Time {
+	return m.last
+}
+
+func (m *memoryTimeTracker) UpdateServiceLast(service string, newTime time.Time) error {
+	if m.checkpoints == nil {
+		m.checkpoints = map[string]time.Time{}
+	}
+	m.checkpoints[service] = newTime
+	return nil
+}
+
+func (m *memoryTimeTracker) GetServiceLastTime(service string) time.Time {
+	if last, ok := m.checkpoints[service]; ok {
+		return last
+	}
+	return m.last
+}
+
+func (m *memoryTimeTracker) Overlap() time.Duration {
+	return m.overlap
+}
+
+func (m *memoryTimeTracker) SeenEvent(service string, id string) bool {
+	return m.seen[service+"/"+id]
+}
+
+func (m *memoryTimeTracker) MarkSeen(service string, id string, _ time.Time) {
+	if m.seen == nil {
+		m.seen = map[string]bool{}
+	}
+	m.seen[service+"/"+id] = true
+}
+
+// payloadConnector is a JumpCloudConnector that decodes a fixed JumpCloud API payload for all services
+type payloadConnector struct {
+	payload   []byte
+	rawEvents bool
+}
+
+func (p *payloadConnector) Services() []string {
+	return []string{AllServices}
+}
+
+func (p *payloadConnector) StreamServiceEventsSinceTime(_ context.Context, _ string, _ time.Time, handle func(JumpCloudEvent) error) error {
+	_, err := decodeJumpCloudEventStream(bytes.NewReader(p.payload), p.rawEvents, handle)
+	return err
+}
+
+// serviceConnector is a JumpCloudConnector that returns a fixed payload per service and records the start time
+// each service was queried with
+type serviceConnector struct {
+	payloads map[string]string
+	services []string
+	started  map[string]time.Time
+	// cancel is called before the events are handed over to simulate a shutdown part way through a run
+	cancel context.CancelFunc
+}
+
+func (s *serviceConnector) Services() []string {
+	return s.services
+}
+
+func (s *serviceConnector) StreamServiceEventsSinceTime(_ context.Context, service string, startTime time.Time, handle func(JumpCloudEvent) error) error {
+	if s.started == nil {
+		s.started = map[string]time.Time{}
+	}
+	s.started[service] = startTime
+	payload, ok := s.payloads[service]
+	if !ok {
+		return fmt.Errorf("service %v is unavailable", service)
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	_, err := decodeJumpCloudEventStream(strings.NewReader(payload), false, handle)
+	return err
+}
+
+func TestRunServiceMixedServices(t *testing.T) {
+	payload, err := os.ReadFile("../test_data/mixed_events.json")
+	if err != nil {
+		t.Fatalf("error reading test payload: %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "output.log")
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)}
+	err = RunService(tracker, &payloadConnector{payload: payload}, output)
+	if err != nil {
+		t.Fatalf("RunService() error = %v", err)
+	}
+	contents, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("error reading output file: %v", err)
+	}
+	got := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		var e struct {
+			ID                 string `json:"id"`
+			JumpCloudEventType string `json:"jumpcloud_event_type"`
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("error decoding output line %q: %v", line, err)
+		}
+		got[e.ID] = e.JumpCloudEventType
+	}
+	want := map[string]string{
+		"dir-1":      "directory",
+		"ldap-1":     "ldap",
+		"sys-1":      "system",
+		"sso-1":      "sso",
+		"radius-1":   "radius",
+		"admin-1":    "admin",
+		"mdm-1":      "mdm",
+		"pwm-1":      "password_manager",
+		"software-1": "software",
+		"alert-1":    "alerts",
+		"storage-1":  "object_storage",
+		"saas-1":     "saas_app_management",
+		"access-1":   "access_management",
+	}
+	if len(got) != len(want) {
+		t.Errorf("RunService() wrote %v events, want %v: %v", len(got), len(want), got)
+	}
+	for id, eventType := range want {
+		if got[id] != eventType {
+			t.Errorf("RunService() event %v jumpcloud_event_type = %q, want %q", id, got[id], eventType)
+		}
+	}
+	wantLast := time.Date(2023, 2, 15, 10, 0, 12, 0, time.UTC)
+	if !tracker.last.Equal(wantLast) {
+		t.Errorf("RunService() last time = %v, want %v", tracker.last, wantLast)
+	}
+}
+
+func TestRunServiceAdminOnly(t *testing.T) {
+	payload := []byte(`[{"service":"admin","event_type":"admin_update","id":"admin-1","timestamp":"2023-02-15T10:00:05Z"}]`)
+	output := filepath.Join(t.TempDir(), "output.log")
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)}
+	err := RunService(tracker, &payloadConnector{payload: payload}, output)
+	if err != nil {
+		t.Fatalf("RunService() error = %v", err)
+	}
+	if !tracker.updated {
+		t.Errorf("RunService() did not advance the last time for an admin only result")
+	}
+	contents, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("error reading output file: %v", err)
+	}
+	if !strings.Contains(string(contents), `"jumpcloud_event_type":"admin"`) {
+		t.Errorf("RunService() output = %v, want an admin event", string(contents))
+	}
+}
+
+func TestRunServicePerServiceCheckpoints(t *testing.T) {
+	start := time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)
+	tracker := &memoryTimeTracker{
+		last:        start,
+		checkpoints: map[string]time.Time{"sso": time.Date(2023, 2, 15, 9, 30, 0, 0, time.UTC)},
+		overlap:     5 * time.Minute,
+	}
+	connector := &serviceConnector{
+		services: []string{"directory", "sso", "systems"},
+		payloads: map[string]string{
+			"directory": `[{"service":"directory","id":"dir-1","timestamp":"2023-02-15T10:00:00Z"}]`,
+			"sso":       `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T09:40:00Z"}]`,
+		},
+	}
+	output := filepath.Join(t.TempDir(), "output.log")
+	err := RunService(tracker, connector, output)
+	if err == nil {
+		t.Errorf("RunService() expected an error for the unavailable systems service")
+	}
+	if got := connector.started["sso"]; !got.Equal(time.Date(2023, 2, 15, 9, 25, 0, 0, time.UTC)) {
+		t.Errorf("RunService() sso queried from %v, want its own checkpoint less the overlap", got)
+	}
+	if got := connector.started["directory"]; !got.Equal(start.Add(-5 * time.Minute)) {
+		t.Errorf("RunService() directory queried from %v, want %v", got, start.Add(-5*time.Minute))
+	}
+	want := map[string]time.Time{
+		"directory": time.Date(2023, 2, 15, 10, 0, 0, 0, time.UTC),
+		"sso":       time.Date(2023, 2, 15, 9, 40, 0, 0, time.UTC),
+	}
+	for service, last := range want {
+		if got := tracker.checkpoints[service]; !got.Equal(last) {
+			t.Errorf("RunService() %v checkpoint = %v, want %v", service, got, last)
+		}
+	}
+	if _, ok := tracker.checkpoints["systems"]; ok {
+		t.Errorf("RunService() advanced the checkpoint of the failed systems service")
+	}
+	if tracker.updated {
+		t.Errorf("RunService() updated the all services last time while collecting individual services")
+	}
+}
+
+func TestRunServiceDeduplicatesOverlap(t *testing.T) {
+	tracker := &memoryTimeTracker{
+		last:    time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC),
+		overlap: 10 * time.Minute,
+	}
+	connector := &serviceConnector{
+		services: []string{"sso"},
+		payloads: map[string]string{
+			"sso": `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00.100Z"}]`,
+		},
+	}
+	output := filepath.Join(t.TempDir(), "output.log")
+	if err := RunService(tracker, connector, output); err != nil {
+		t.Fatalf("RunService() error = %v", err)
+	}
+	// The second run sees the first event again inside the overlap window along with one from the same second
+	connector.payloads["sso"] = `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00.100Z"},` +
+		`{"service":"sso","id":"sso-2","timestamp":"2023-02-15T10:00:00.900Z"}]`
+	if err := RunService(tracker, connector, output); err != nil {
+		t.Fatalf("RunService() error = %v", err)
+	}
+	if got := connector.started["sso"]; !got.Equal(time.Date(2023, 2, 15, 9, 50, 0, 100000000, time.UTC)) {
+		t.Errorf("RunService() second run queried from %v, want the checkpoint less the overlap", got)
+	}
+	contents, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("error reading output file: %v", err)
+	}
+	if got := strings.Count(string(contents), `"id":"sso-1"`); got != 1 {
+		t.Errorf("RunService() wrote sso-1 %v times, want 1", got)
+	}
+	if got := strings.Count(string(contents), `"id":"sso-2"`); got != 1 {
+		t.Errorf("RunService() wrote sso-2 %v times, want 1", got)
+	}
+}
+
+func TestReadOnlyTimeTracker(t *testing.T) {
+	last := time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)
+	tracker := &memoryTimeTracker{last: last, overlap: 10 * time.Minute}
+	connector := &serviceConnector{
+		services: []string{"sso"},
+		payloads: map[string]string{"sso": `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00Z"}]`},
+	}
+	dryRun := ReadOnlyTimeTracker(tracker)
+	out := &bytes.Buffer{}
+	// A dry run in daemon mode runs again with the same tracker and must not print the same events every time
+	for i := 0; i < 2; i++ {
+		if err := RunServiceToWriter(dryRun, connector, out); err != nil {
+			t.Fatalf("RunServiceToWriter() error = %v", err)
+		}
+	}
+	if got := strings.Count(out.String(), `"id":"sso-1"`); got != 1 {
+		t.Errorf("RunServiceToWriter() wrote sso-1 %v times, want 1", got)
+	}
+	if got := connector.started["sso"]; !got.Equal(time.Date(2023, 2, 15, 9, 50, 0, 0, time.UTC)) {
+		t.Errorf("RunServiceToWriter() second run queried from %v, want the in memory checkpoint less the overlap", got)
+	}
+	if len(tracker.checkpoints) != 0 || len(tracker.seen) != 0 {
+		t.Errorf("ReadOnlyTimeTracker() changed the wrapped tracker: %v %v", tracker.checkpoints, tracker.seen)
+	}
+}
+
+func TestRunServiceContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)
+	tracker := &memoryTimeTracker{last: start}
+	connector := &serviceConnector{
+		services: []string{"sso", "directory"},
+		payloads: map[string]string{
+			"sso":       `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00Z"}]`,
+			"directory": `[{"service":"directory","id":"dir-1","timestamp":"2023-02-15T10:00:00Z"}]`,
+		},
+		cancel: cancel,
+	}
+	output := filepath.Join(t.TempDir(), "output.log")
+	err := RunServiceContext(ctx, tracker, connector, output)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunServiceContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, ok := connector.started["directory"]; ok {
+		t.Errorf("RunServiceContext() queried another service after being cancelled")
+	}
+	if _, ok := tracker.checkpoints["sso"]; ok {
+		t.Errorf("RunServiceContext() checkpointed events it did not write")
+	}
+	contents, _ := os.ReadFile(output)
+	if len(contents) != 0 {
+		t.Errorf("RunServiceContext() wrote events after being cancelled: %v", string(contents))
+	}
+}
+
+func TestRunServiceCheckpointsPartialQuery(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Search_after", "[1]")
+		w.Header().Set("X-Result-Count", "2")
+		fmt.Fprint(w, `[{"service":"sso","id":"a","timestamp":"2023-02-15T10:00:00Z"},{"service":"sso","id":"b","timestamp":"2023-02-15T10:00:05Z"}]`)
+	}))
+	defer server.Close()
+	a := newTestJumpCloudAPI(NewJumpCloudAPIOptions{
+		APIKey:   "key",
+		BaseURL:  server.URL,
+		Services: []ServiceQuery{{Service: "sso", Limit: 2}},
+	})
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)}
+	var output strings.Builder
+	err := RunServiceToWriter(tracker, a, &output)
+	if err == nil {
+		t.Errorf("RunServiceToWriter() expected an error when a later page fails")
+	}
+	if got := strings.Count(output.String(), "\n"); got != 2 {
+		t.Errorf("RunServiceToWriter() wrote %v events, want 2", got)
+	}
+	want := time.Date(2023, 2, 15, 10, 0, 5, 0, time.UTC)
+	if !tracker.checkpoints["sso"].Equal(want) {
+		t.Errorf("RunServiceToWriter() checkpoint = %v, want %v", tracker.checkpoints["sso"], want)
+	}
+}
+
+func TestRunServiceUnconfirmedSink(t *testing.T) {
+	start := time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC)
+	tracker := &memoryTimeTracker{last: start}
+	connector := &serviceConnector{
+		services: []string{"sso"},
+		payloads: map[string]string{"sso": `[{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:00Z"}]`},
+	}
+	sink := &recordingSink{flushErr: errors.New("unavailable")}
+	err := RunServiceToSinkContext(context.Background(), tracker, connector, sink)
+	if err == nil {
+		t.Errorf("RunServiceToSinkContext() expected an error when the sink does not confirm its events")
+	}
+	if _, ok := tracker.checkpoints["sso"]; ok {
+		t.Errorf("RunServiceToSinkContext() moved the checkpoint past unconfirmed events")
+	}
+	if tracker.SeenEvent("sso", "sso-1") {
+		t.Errorf("RunServiceToSinkContext() marked an unconfirmed event as seen")
+	}
+}
+
+// failingWriter fails the writes whose number, counting from one, is in fail
+type failingWriter struct {
+	fail   map[int]bool
+	writes int
+	lines  []string
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.fail[f.writes] {
+		return 0, errors.New("no space left on device")
+	}
+	f.lines = append(f.lines, string(p))
+	return len(p), nil
+}
+
+func TestRunServiceFailingWriter(t *testing.T) {
+	payload := `[
+		{"service":"sso","id":"sso-1","timestamp":"2023-02-15T10:00:01Z"},
+		{"service":"sso","id":"sso-2","timestamp":"2023-02-15T10:00:02Z"},
+		{"service":"sso","id":"sso-3","timestamp":"2023-02-15T10:00:03Z"},
+		{"service":"sso","id":"sso-4","timestamp":"2023-02-15T10:00:04Z"},
+		{"service":"sso","id":"sso-5","timestamp":"2023-02-15T10:00:05Z"}
+	]`
+	tracker := &memoryTimeTracker{last: time.Date(2023, 2, 15, 9, 0, 0, 0, time.UTC), overlap: time.Hour}
+	connector := &serviceConnector{services: []string{"sso"}, payloads: map[string]string{"sso": payload}}
+
+	w := &failingWriter{fail: map[int]bool{3: true, 4: true}}
+	err := RunServiceToWriter(tracker, connector, w)
+	if err == nil || !strings.Contains(err.Error(), "2 of 5") {
+		t.Errorf("RunServiceToWriter() error = %v, want 2 of 5 events reported as not written", err)
+	}
+	// The checkpoint stops before the first failed event even though a later event was written
+	if want := time.Date(2023, 2, 15, 10, 0, 2, 0, time.UTC); !tracker.checkpoints["sso"].Equal(want) {
+		t.Errorf("RunServiceToWriter() checkpoint = %v, want %v", tracker.checkpoints["sso"], want)
+	}
+	for id, want := range map[string]bool{"sso-1": true, "sso-2": true, "sso-3": false, "sso-4": false, "sso-5": true} {
+		if got := tracker.SeenEvent("sso", id); got != want {
+			t.Errorf("RunServiceToWriter() seen %v = %v, want %v", id, got, want)
+		}
+	}
+
+	// The next run writes only the failed events and catches the checkpoint up
+	w = &failingWriter{}
+	err = RunServiceToWriter(tracker, connector, w)
+	if err != nil {
+		t.Fatalf("RunServiceToWriter() error = %v", err)
+	}
+	if len(w.lines) != 2 || !strings.Contains(w.lines[0], "sso-3") || !strings.Contains(w.lines[1], "sso-4") {
+		t.Errorf("RunServiceToWriter() wrote %v, want only sso-3 and sso-4", w.lines)
+	}
+	if want := time.Date(2023, 2, 15, 10, 0, 5, 0, time.UTC); !tracker.checkpoints["sso"].Equal(want) {
+		t.Errorf("RunServiceToWriter() checkpoint = %v, want %v", tracker.checkpoints["sso"], want)
+	}
+}
@@ -0,0 +1,450 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// EventSink is a destination for JumpCloud events.  Events are written one at a time and the checkpoint is only
+// moved once Flush confirms the events that were written are durable
+type EventSink interface {
+	// WriteEvent delivers a single event, payload is the event as a single line of JSON without a trailing newline.
+	// An event that returns an error was not delivered
+	WriteEvent(payload []byte) error
+	// Flush makes every event WriteEvent accepted since the last Flush durable, such as by syncing a file to disk.
+	// It returns an error if any of them may still be lost
+	Flush() error
+	// Close releases the sink, it is not used again afterwards
+	Close() error
+}
+
+// documentSink is implemented by the sinks in front of the outputs, such as filters, redactors and formatters, so an
+// event passed down the chain is decoded once instead of by every sink
+type documentSink interface {
+	// writeDocument is WriteEvent for an event that is already decoded, the sink may change it
+	writeDocument(event eventDocument) error
+}
+
+// writeDocument writes event to sink, it is only encoded if sink can not take the decoded event
+func writeDocument(sink EventSink, event eventDocument) error {
+	if d, ok := sink.(documentSink); ok {
+		return d.writeDocument(event)
+	}
+	payload, err := event.encode()
+	if err != nil {
+		return err
+	}
+	return sink.WriteEvent(payload)
+}
+
+// Output types that can be configured in the outputs list
+const (
+	OutputFile   = "file"
+	OutputStdout = "stdout"
+	OutputSyslog = "syslog"
+	OutputWazuh  = "wazuh"
+)
+
+// OutputConfig configures a single destination for events
+type OutputConfig struct {
+	// Type is one of file, stdout, syslog or wazuh
+	Type string `json:"type"`
+	// Path is the file events are appended to for the file output, or the analysisd queue socket for the wazuh
+	// output where it defaults to /var/ossec/queue/sockets/queue
+	Path string `json:"path,omitempty"`
+	// Format is the format events are sent in, wazuh by default, ecs, ocsf, cef, leef or flat
+	Format string `json:"format,omitempty"`
+	// KeySeparator joins the keys of the flat format, . by default or _
+	KeySeparator string `json:"key_separator,omitempty"`
+	// Vendor, Product and ProductVersion are the device fields of the cef and leef headers, they default to
+	// JumpCloud, Directory Insights and 1.0
+	Vendor         string `json:"vendor,omitempty"`
+	Product        string `json:"product,omitempty"`
+	ProductVersion string `json:"product_version,omitempty"`
+	// MaxSizeMB, MaxAge, MaxBackups and Compress rotate the file output, see FileRotation.  Rotation is off unless
+	// max_size_mb or max_age is set
+	MaxSizeMB  int       `json:"max_size_mb,omitempty"`
+	MaxAge     *Duration `json:"max_age,omitempty"`
+	MaxBackups int       `json:"max_backups,omitempty"`
+	Compress   bool      `json:"compress,omitempty"`
+	// Network and Address send the syslog output to a relay over udp, tcp or tls, without them events go to the
+	// local syslog daemon
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	// AppName and Facility are used by the syslog output, they default to jumpcloud and local0
+	AppName  string `json:"app_name,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	// StructuredData is added to every message sent to a syslog relay, keyed by SD-ID and then parameter name
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	// CAFile verifies the relay for the tls network instead of the system roots, CertFile and KeyFile are a client
+	// certificate for relays that require one and ServerName overrides the name checked in the relay's certificate
+	CAFile     string `json:"ca_file,omitempty"`
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	// Required defaults to true, the checkpoint does not move unless every required output confirms its events.
+	// Failures of an output that is not required are logged and otherwise ignored
+	Required *bool `json:"required,omitempty"`
+}
+
+// IsRequired returns true unless the output was explicitly marked as not required
+func (o OutputConfig) IsRequired() bool {
+	return o.Required == nil || *o.Required
+}
+
+// name identifies the output in log messages and errors
+func (o OutputConfig) name() string {
+	if o.Path != "" {
+		return o.Type + " " + o.Path
+	}
+	if o.Address != "" {
+		return o.Type + " " + o.Address
+	}
+	return o.Type
+}
+
+// validate checks the output can be opened
+func (o OutputConfig) validate() error {
+	err := validFormat(o.Format)
+	if err != nil {
+		return err
+	}
+	err = validKeySeparator(o.KeySeparator)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case OutputFile:
+		if o.Path == "" {
+			return fmt.Errorf("file output is missing a path")
+		}
+		if o.MaxSizeMB < 0 || o.MaxBackups < 0 || (o.MaxAge != nil && o.MaxAge.Duration < 0) {
+			return fmt.Errorf("file output %v rotation settings can not be negative", o.Path)
+		}
+		if o.Compress && o.MaxBackups == 1 {
+			return fmt.Errorf("file output %v compress needs max_backups of at least 2, the newest backup is never compressed", o.Path)
+		}
+	case OutputStdout, OutputWazuh:
+	case OutputSyslog:
+		if o.Network != "" {
+			return o.syslogOptions().validate()
+		}
+		if o.Facility != "" {
+			if _, ok := syslogFacilities[o.Facility]; !ok {
+				return fmt.Errorf("syslog output has unknown facility %q", o.Facility)
+			}
+		}
+		if o.Address != "" || len(o.StructuredData) > 0 {
+			return fmt.Errorf("syslog output needs a network to send to an address or add structured data")
+		}
+	case "":
+		return fmt.Errorf("output entry is missing a type")
+	default:
+		return fmt.Errorf("unknown output type %q, expected one of file, stdout, syslog or wazuh", o.Type)
+	}
+	return nil
+}
+
+// rotation returns the rotation settings of a file output
+func (o OutputConfig) rotation() FileRotation {
+	rotation := FileRotation{
+		MaxSize:    int64(o.MaxSizeMB) * 1024 * 1024,
+		MaxBackups: o.MaxBackups,
+		Compress:   o.Compress,
+	}
+	if o.MaxAge != nil {
+		rotation.MaxAge = o.MaxAge.Duration
+	}
+	return rotation
+}
+
+// syslogOptions returns the options of a syslog output that sends to a relay, the TLS settings are loaded by open
+func (o OutputConfig) syslogOptions() SyslogOptions {
+	return SyslogOptions{
+		Network:        o.Network,
+		Address:        o.Address,
+		Facility:       o.Facility,
+		AppName:        o.AppName,
+		StructuredData: o.StructuredData,
+	}
+}
+
+// tlsConfig loads the certificates of a syslog output that uses tls
+func (o OutputConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: o.ServerName, MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// open returns the sink for the output, formatting events if the output has a format
+func (o OutputConfig) open() (EventSink, error) {
+	sink, err := o.openDestination()
+	if err != nil {
+		return nil, err
+	}
+	return newFormatSink(sink, o.formatter()), nil
+}
+
+// formatter returns the formatter of the output's format, or nil if events are sent as they are
+func (o OutputConfig) formatter() eventFormatter {
+	switch o.Format {
+	case FormatECS:
+		return formatECS
+	case FormatOCSF:
+		return formatOCSF
+	case FormatCEF:
+		return o.siemHeader().formatCEF
+	case FormatLEEF:
+		return o.siemHeader().formatLEEF
+	case FormatFlat:
+		f := flatFormatter{separator: o.KeySeparator}
+		if f.separator == "" {
+			f.separator = defaultKeySeparator
+		}
+		return f.format
+	}
+	return nil
+}
+
+// siemHeader returns the device fields of the cef and leef headers
+func (o OutputConfig) siemHeader() siemHeader {
+	h := siemHeader{Vendor: o.Vendor, Product: o.Product, Version: o.ProductVersion}
+	if h.Vendor == "" {
+		h.Vendor = defaultSIEMVendor
+	}
+	if h.Product == "" {
+		h.Product = defaultSIEMProduct
+	}
+	if h.Version == "" {
+		h.Version = defaultSIEMVersion
+	}
+	return h
+}
+
+// openDestination returns the sink events are written to, before they are formatted
+func (o OutputConfig) openDestination() (EventSink, error) {
+	switch o.Type {
+	case OutputFile:
+		return NewRotatingFileSink(o.Path, o.rotation())
+	case OutputStdout:
+		return NewWriterSink(os.Stdout), nil
+	case OutputSyslog:
+		if o.Network == "" {
+			return NewLocalSyslogSink(o.AppName, o.Facility)
+		}
+		options := o.syslogOptions()
+		if o.Network == SyslogTLS {
+			config, err := o.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			options.TLSConfig = config
+		}
+		return NewSyslogSink(options)
+	case OutputWazuh:
+		return NewWazuhQueueSink(o.Path)
+	}
+	return nil, fmt.Errorf("unknown output type %q", o.Type)
+}
+
+// syslogFacilities are the facility names a syslog output accepts and their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Defaults for the syslog output
+const (
+	defaultSyslogAppName  = "jumpcloud"
+	defaultSyslogFacility = "local0"
+)
+
+// OpenOutputs opens every configured output and returns a sink that sends each event to all of them
+func OpenOutputs(outputs []OutputConfig) (EventSink, error) {
+	var routes []SinkRoute
+	for _, x := range outputs {
+		sink, err := x.open()
+		if err != nil {
+			for _, r := range routes {
+				r.Sink.Close()
+			}
+			return nil, fmt.Errorf("error opening %v output: %w", x.name(), err)
+		}
+		routes = append(routes, SinkRoute{Name: x.name(), Sink: sink, Required: x.IsRequired()})
+	}
+	return NewFanOutSink(routes...), nil
+}
+
+// line returns a copy of payload ending in a newline, payload itself is shared by every sink and is never changed
+func line(payload []byte) []byte {
+	b := make([]byte, len(payload)+1)
+	copy(b, payload)
+	b[len(payload)] = '\n'
+	return b
+}
+
+// writerSink writes every event as a line to an io.Writer
+type writerSink struct {
+	w io.Writer
+}
+
+// NewWriterSink returns a sink that writes every event as a line to w, it never closes w
+func NewWriterSink(w io.Writer) EventSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) WriteEvent(payload []byte) error {
+	_, err := s.w.Write(line(payload))
+	return err
+}
+
+// Flush flushes writers that buffer, such as a bufio.Writer
+func (s *writerSink) Flush() error {
+	if f, ok := s.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// SinkRoute is one of the sinks a fan-out sink sends events to
+type SinkRoute struct {
+	// Name identifies the sink in log messages and errors
+	Name string
+	Sink EventSink
+	// Required sinks must confirm every event before the checkpoint moves, failures of other sinks are only logged
+	Required bool
+}
+
+// fanOutSink sends every event to several sinks
+type fanOutSink struct {
+	routes []SinkRoute
+	// failed counts the events each sink did not accept since the last Flush
+	failed []int
+	mu     sync.Mutex
+}
+
+// NewFanOutSink returns a sink that sends every event to all the given sinks.  A write or Flush only fails if a
+// required sink fails, failures of the other sinks are logged
+func NewFanOutSink(routes ...SinkRoute) EventSink {
+	return &fanOutSink{routes: routes, failed: make([]int, len(routes))}
+}
+
+func (s *fanOutSink) WriteEvent(payload []byte) error {
+	return s.write(func(sink EventSink) error {
+		return sink.WriteEvent(payload)
+	})
+}
+
+// writeDocument hands the decoded event to the outputs that format it and encodes it once for all the others.
+// Formatters only read the event so every output can share it, other sinks such as a redactor may change it and are
+// given their own copy as a payload
+func (s *fanOutSink) writeDocument(event eventDocument) error {
+	var payload []byte
+	return s.write(func(sink EventSink) error {
+		if f, ok := sink.(*formatSink); ok {
+			return f.writeDocument(event)
+		}
+		if payload == nil {
+			var err error
+			payload, err = event.encode()
+			if err != nil {
+				return err
+			}
+		}
+		return sink.WriteEvent(payload)
+	})
+}
+
+// write calls write for every sink and collects the failures of the required ones
+func (s *fanOutSink) write(write func(sink EventSink) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for i, r := range s.routes {
+		err := write(r.Sink)
+		// An output that filters out the event accepted it all the same
+		if err == nil || errors.Is(err, ErrEventFiltered) {
+			continue
+		}
+		s.failed[i]++
+		if r.Required {
+			errs = append(errs, fmt.Errorf("%v: %w", r.Name, err))
+		} else if s.failed[i] == 1 {
+			logWarnf("Error writing to optional %v output, its events since the last checkpoint may be lost: %v", r.Name, err)
+		}
+	}
+	return joinSinkErrors(errs)
+}
+
+func (s *fanOutSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for i, r := range s.routes {
+		err := r.Sink.Flush()
+		failed := s.failed[i]
+		s.failed[i] = 0
+		if !r.Required && failed > 0 {
+			logWarnf("Optional %v output did not accept %v events", r.Name, failed)
+		}
+		if err == nil {
+			continue
+		}
+		if r.Required {
+			errs = append(errs, fmt.Errorf("%v: %w", r.Name, err))
+		} else {
+			logWarnf("Optional %v output did not confirm its events: %v", r.Name, err)
+		}
+	}
+	return joinSinkErrors(errs)
+}
+
+func (s *fanOutSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, r := range s.routes {
+		err := r.Sink.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", r.Name, err))
+		}
+	}
+	return joinSinkErrors(errs)
+}
+
+// joinSinkErrors returns nil if errs is empty, otherwise the first error noting how many other sinks failed
+func joinSinkErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %v more outputs failed)", errs[0], len(errs)-1)
+}
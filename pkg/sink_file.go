@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultMaxBackups is how many rotated files are kept when rotation is enabled without a number of backups
+const defaultMaxBackups = 5
+
+// FileRotation configures when the file output is rotated, a zero MaxSize and MaxAge never rotate
+type FileRotation struct {
+	// MaxSize is the size in bytes a file may grow to before it is rotated
+	MaxSize int64
+	// MaxAge is how long after the last rotation a file is rotated, a file that was never rotated is aged from the
+	// first time it is opened with MaxAge set
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept, defaults to 5
+	MaxBackups int
+	// Compress gzips rotated files from the second backup on, the first stays plain for one rotation as logcollector
+	// may still be reading it.  With a single backup nothing is ever compressed
+	Compress bool
+}
+
+// enabled returns true if the file can ever be rotated
+func (r FileRotation) enabled() bool {
+	return r.MaxSize > 0 || r.MaxAge > 0
+}
+
+// fileSink appends every event as a line to a file.  The file is closed on every Flush and opened again by the next
+// write, so a file moved away by logrotate is replaced rather than written to forever.  When rotation is configured
+// the file is renamed to path.1 and a new file is started, older backups move up one number.  The file is never
+// truncated so Wazuh's logcollector, which follows a renamed file to its end before opening the new one, never
+// misses or repeats a line
+type fileSink struct {
+	path     string
+	rotation FileRotation
+	f        *os.File
+	// size is the size of the open file and rotated is when it was last rotated
+	size    int64
+	rotated time.Time
+	now     func() time.Time
+}
+
+// NewFileSink returns a sink that appends events to the file at path, creating it if it does not exist
+func NewFileSink(path string) (EventSink, error) {
+	return NewRotatingFileSink(path, FileRotation{})
+}
+
+// NewRotatingFileSink is NewFileSink for a file that is rotated by size or age
+func NewRotatingFileSink(path string, rotation FileRotation) (EventSink, error) {
+	if rotation.enabled() && rotation.MaxBackups <= 0 {
+		rotation.MaxBackups = defaultMaxBackups
+	}
+	s := &fileSink{path: path, rotation: rotation, now: time.Now}
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	// The file is reopened after every Flush, only the first open looks up when it was last rotated
+	if s.rotated.IsZero() && s.rotation.MaxAge > 0 {
+		s.rotated = s.lastRotation()
+	}
+	return nil
+}
+
+// lastRotation returns when the file was last rotated, read from the rotation marker or, for files rotated before
+// the marker was kept, the newest backup.  A file that was never rotated is aged from now and the time is recorded
+// so the next run does not start its age again
+func (s *fileSink) lastRotation() time.Time {
+	if b, err := os.ReadFile(s.markerPath()); err == nil {
+		if rotated, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b))); err == nil {
+			return rotated
+		}
+	}
+	if backup, err := s.newestBackup(); err == nil {
+		return backup.ModTime()
+	}
+	now := s.now()
+	s.recordRotation(now)
+	return now
+}
+
+// recordRotation stores the rotation time next to the file, a file without it is aged from the next run
+func (s *fileSink) recordRotation(rotated time.Time) {
+	err := writeFileAtomic(s.markerPath(), []byte(rotated.UTC().Format(time.RFC3339Nano)), 0644)
+	if err != nil {
+		logWarnf("Error recording when %v was rotated: %v", s.path, err)
+	}
+}
+
+// markerPath is the hidden file that holds the last rotation time, it does not match the backup names
+func (s *fileSink) markerPath() string {
+	return filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".rotated")
+}
+
+// newestBackup returns the most recently rotated file, which was last written when it was rotated
+func (s *fileSink) newestBackup() (os.FileInfo, error) {
+	info, err := os.Stat(s.backupPath(1))
+	if err != nil {
+		info, err = os.Stat(s.backupPath(1) + ".gz")
+	}
+	return info, err
+}
+
+func (s *fileSink) backupPath(n int) string {
+	return fmt.Sprintf("%v.%v", s.path, n)
+}
+
+func (s *fileSink) WriteEvent(payload []byte) error {
+	if s.f == nil {
+		err := s.open()
+		if err != nil {
+			return err
+		}
+	}
+	b := line(payload)
+	if s.shouldRotate(int64(len(b))) {
+		err := s.rotate()
+		if err != nil {
+			return fmt.Errorf("error rotating %v: %w", s.path, err)
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// shouldRotate returns true if writing n more bytes would take the file over its size limit, or it is too old.  An
+// empty file is never rotated so an event larger than the limit is still written
+func (s *fileSink) shouldRotate(n int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.rotation.MaxSize > 0 && s.size+n > s.rotation.MaxSize {
+		return true
+	}
+	return s.rotation.MaxAge > 0 && s.now().Sub(s.rotated) >= s.rotation.MaxAge
+}
+
+// rotate renames the file to the first backup and opens a new file in its place
+func (s *fileSink) rotate() error {
+	err := s.f.Sync()
+	if err != nil {
+		return err
+	}
+	err = s.Close()
+	if err != nil {
+		return err
+	}
+	// Drop the oldest backup and move the others up one number to free the first one
+	for _, suffix := range []string{"", ".gz"} {
+		err = os.Remove(s.backupPath(s.rotation.MaxBackups) + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for n := s.rotation.MaxBackups - 1; n >= 1; n-- {
+		for _, suffix := range []string{"", ".gz"} {
+			err = os.Rename(s.backupPath(n)+suffix, s.backupPath(n+1)+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	err = os.Rename(s.path, s.backupPath(1))
+	if err != nil {
+		return err
+	}
+	logInfof("Rotated %v", s.path)
+	if s.rotation.Compress && s.rotation.MaxBackups > 1 {
+		// The previous backup has had a whole rotation for readers to finish with it.  A backup that can not be
+		// compressed is still kept, uncompressed
+		err = compressFile(s.backupPath(2))
+		if err != nil && !os.IsNotExist(err) {
+			logWarnf("Error compressing %v: %v", s.backupPath(2), err)
+		}
+	}
+	err = s.open()
+	if err != nil {
+		return err
+	}
+	s.rotated = s.now()
+	if s.rotation.MaxAge > 0 {
+		s.recordRotation(s.rotated)
+	}
+	return nil
+}
+
+// compressFile gzips path to path.gz and removes path once the compressed copy is safely on disk
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path+".gz")
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Flush syncs the file to disk so the events survive a crash and closes it, the next write opens it again
+func (s *fileSink) Flush() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Sync()
+	closeErr := s.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.log")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	if err := sink.WriteEvent([]byte(`{"id":"a"}`)); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	// A file moved away between runs, as logrotate does, is replaced by a new file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("error moving output file: %v", err)
+	}
+	if err := sink.WriteEvent([]byte(`{"id":"b"}`)); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	for file, want := range map[string]string{path + ".1": "{\"id\":\"a\"}\n", path: "{\"id\":\"b\"}\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("error reading %v: %v", file, err)
+		}
+		if string(got) != want {
+			t.Errorf("FileSink %v got = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
+
+// readLogFile returns the contents of a plain or gzipped file
+func readLogFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening %v: %v", path, err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("error reading %v: %v", path, err)
+		}
+		r = gz
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading %v: %v", path, err)
+	}
+	return string(b)
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	// Every event is 10 bytes with its newline so a 20 byte limit holds two events per file
+	tests := []struct {
+		name      string
+		rotation  FileRotation
+		events    int
+		wantFiles map[string]string
+		wantGone  []string
+	}{
+		{
+			name:     "TestFileSinkRotatesBySize",
+			rotation: FileRotation{MaxSize: 20, MaxBackups: 2},
+			events:   7,
+			wantFiles: map[string]string{
+				"output.log":   "event-006\n",
+				"output.log.1": "event-004\nevent-005\n",
+				"output.log.2": "event-002\nevent-003\n",
+			},
+			wantGone: []string{"output.log.3"},
+		},
+		{
+			name:     "TestFileSinkRotatesAndCompresses",
+			rotation: FileRotation{MaxSize: 20, MaxBackups: 2, Compress: true},
+			events:   5,
+			wantFiles: map[string]string{
+				"output.log":      "event-004\n",
+				"output.log.1":    "event-002\nevent-003\n",
+				"output.log.2.gz": "event-000\nevent-001\n",
+			},
+			// The newest backup is compressed one rotation later, logcollector may still be reading it
+			wantGone: []string{"output.log.1.gz", "output.log.2"},
+		},
+		{
+			name:     "TestFileSinkDefaultBackups",
+			rotation: FileRotation{MaxSize: 10},
+			events:   8,
+			wantFiles: map[string]string{
+				"output.log":   "event-007\n",
+				"output.log.5": "event-002\n",
+			},
+			wantGone: []string{"output.log.6"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := NewRotatingFileSink(filepath.Join(dir, "output.log"), tt.rotation)
+			if err != nil {
+				t.Fatalf("NewRotatingFileSink() error = %v", err)
+			}
+			defer sink.Close()
+			for i := 0; i < tt.events; i++ {
+				if err := sink.WriteEvent([]byte(fmt.Sprintf("event-%03d", i))); err != nil {
+					t.Fatalf("WriteEvent() error = %v", err)
+				}
+			}
+			if err := sink.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			for name, want := range tt.wantFiles {
+				if got := readLogFile(t, filepath.Join(dir, name)); got != want {
+					t.Errorf("FileSink %v got = %q, want %q", name, got, want)
+				}
+			}
+			for _, name := range tt.wantGone {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("FileSink %v exists, want it removed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSinkRotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.log")
+	sink, err := NewRotatingFileSink(path, FileRotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("NewRotatingFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	fs := sink.(*fileSink)
+	now := fs.rotated
+	fs.now = func() time.Time { return now }
+	// Every write is flushed so the file is closed and opened again between them, as it is between polls
+	write := func(payload string) {
+		if err := sink.WriteEvent([]byte(payload)); err != nil {
+			t.Fatalf("WriteEvent() error = %v", err)
+		}
+		if err := sink.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+	write("first")
+	now = now.Add(59 * time.Minute)
+	write("second")
+	now = now.Add(time.Minute)
+	write("third")
+	if got, want := readLogFile(t, path+".1"), "first\nsecond\n"; got != want {
+		t.Errorf("FileSink rotated file got = %q, want %q", got, want)
+	}
+	if got, want := readLogFile(t, path), "third\n"; got != want {
+		t.Errorf("FileSink current file got = %q, want %q", got, want)
+	}
+}
+
+func TestFileSinkAgeSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.log")
+	// A file written before max_age was configured has no backup and is not rotated as soon as it is written to
+	if err := os.WriteFile(path, []byte("existing\n"), 0644); err != nil {
+		t.Fatalf("error writing output file: %v", err)
+	}
+	rotation := FileRotation{MaxAge: time.Hour}
+	sink, err := NewRotatingFileSink(path, rotation)
+	if err != nil {
+		t.Fatalf("NewRotatingFileSink() error = %v", err)
+	}
+	if err := sink.WriteEvent([]byte("first")); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("FileSink rotated a file of unknown age on its first write")
+	}
+	// The next run ages the file from the same time instead of starting again
+	started := sink.(*fileSink).rotated
+	sink, err = NewRotatingFileSink(path, rotation)
+	if err != nil {
+		t.Fatalf("NewRotatingFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	if got := sink.(*fileSink).rotated; !got.Equal(started) {
+		t.Errorf("FileSink rotated after restart = %v, want %v", got, started)
+	}
+}
+
+func TestFileSinkRotationKeepsReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.log")
+	sink, err := NewRotatingFileSink(path, FileRotation{MaxSize: 20})
+	if err != nil {
+		t.Fatalf("NewRotatingFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	if err := sink.WriteEvent([]byte("event-000")); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	// A reader such as logcollector holds the file open while it is rotated
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening output file: %v", err)
+	}
+	defer reader.Close()
+	for i := 1; i < 3; i++ {
+		if err := sink.WriteEvent([]byte(fmt.Sprintf("event-%03d", i))); err != nil {
+			t.Fatalf("WriteEvent() error = %v", err)
+		}
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("error reading output file: %v", err)
+	}
+	if want := "event-000\nevent-001\n"; string(got) != want {
+		t.Errorf("FileSink reader got = %q, want %q, the rotated file must be renamed and never truncated", got, want)
+	}
+}
+
+func TestOutputConfig_validateRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  OutputConfig
+		wantErr bool
+	}{
+		{
+			name:   "TestRotationValid",
+			output: OutputConfig{Type: OutputFile, Path: "output.log", MaxSizeMB: 100, MaxBackups: 7, Compress: true},
+		},
+		{
+			name:   "TestRotationCompressDefaultBackups",
+			output: OutputConfig{Type: OutputFile, Path: "output.log", MaxSizeMB: 100, Compress: true},
+		},
+		{
+			name:    "TestRotationNegativeBackups",
+			output:  OutputConfig{Type: OutputFile, Path: "output.log", MaxSizeMB: 100, MaxBackups: -1},
+			wantErr: true,
+		},
+		{
+			name:    "TestRotationCompressSingleBackup",
+			output:  OutputConfig{Type: OutputFile, Path: "output.log", MaxSizeMB: 100, MaxBackups: 1, Compress: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.output.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package pkg
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// localSyslogSink sends every event to the local syslog daemon
+type localSyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewLocalSyslogSink returns a sink that sends events to the local syslog daemon at the info level.  An empty app
+// name or facility defaults to jumpcloud and local0
+func NewLocalSyslogSink(appName string, facility string) (EventSink, error) {
+	if appName == "" {
+		appName = defaultSyslogAppName
+	}
+	if facility == "" {
+		facility = defaultSyslogFacility
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	w, err := syslog.New(syslog.Priority(code<<3)|syslog.LOG_INFO, appName)
+	if err != nil {
+		return nil, err
+	}
+	return &localSyslogSink{w: w}, nil
+}
+
+func (s *localSyslogSink) WriteEvent(payload []byte) error {
+	// The syslog writer reconnects on its own if the daemon restarts
+	return s.w.Info(string(payload))
+}
+
+// Flush has nothing to do, the syslog daemon accepted each event as it was written
+func (s *localSyslogSink) Flush() error {
+	return nil
+}
+
+func (s *localSyslogSink) Close() error {
+	return s.w.Close()
+}
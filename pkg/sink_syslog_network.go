@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rfc5424Time is the RFC 5424 TIMESTAMP, RFC 3339 with at most microseconds
+	rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+	// syslogSeverityInfo is the severity every event is sent with
+	syslogSeverityInfo = 6
+	// syslogDialTimeout and syslogWriteTimeout limit how long a syslog relay can hold up a run
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 30 * time.Second
+	// Longest APP-NAME and SD-ID RFC 5424 allows
+	syslogMaxAppName = 48
+	syslogMaxSDName  = 32
+)
+
+// Transports the network syslog output supports
+const (
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// SyslogOptions configures a network syslog sink
+type SyslogOptions struct {
+	// Network is udp, tcp or tls
+	Network string
+	// Address is the host:port of the syslog relay
+	Address string
+	// Facility defaults to local0 and AppName to jumpcloud
+	Facility string
+	AppName  string
+	// StructuredData is added to every message, keyed by SD-ID and then parameter name
+	StructuredData map[string]map[string]string
+	// TLSConfig is used by the tls network, the server name defaults to the host of Address
+	TLSConfig *tls.Config
+}
+
+// validate checks the options can be turned into valid RFC 5424 messages
+func (o SyslogOptions) validate() error {
+	switch o.Network {
+	case SyslogUDP, SyslogTCP, SyslogTLS:
+	default:
+		return fmt.Errorf("unknown syslog network %q, expected one of udp, tcp or tls", o.Network)
+	}
+	if o.Address == "" {
+		return fmt.Errorf("syslog output is missing an address")
+	}
+	if o.Facility != "" {
+		if _, ok := syslogFacilities[o.Facility]; !ok {
+			return fmt.Errorf("syslog output has unknown facility %q", o.Facility)
+		}
+	}
+	if o.AppName != "" && !validSyslogName(o.AppName, syslogMaxAppName) {
+		return fmt.Errorf("syslog app_name %q must be at most %v printable characters without spaces", o.AppName, syslogMaxAppName)
+	}
+	for id, params := range o.StructuredData {
+		if !validSyslogName(id, syslogMaxSDName) {
+			return fmt.Errorf("syslog structured data ID %q must be at most %v printable characters without spaces, =, ] or \"", id, syslogMaxSDName)
+		}
+		for name := range params {
+			if !validSyslogName(name, syslogMaxSDName) {
+				return fmt.Errorf("syslog structured data parameter %q must be at most %v printable characters without spaces, =, ] or \"", name, syslogMaxSDName)
+			}
+		}
+	}
+	return nil
+}
+
+// validSyslogName returns true if s is a valid RFC 5424 APP-NAME or SD-NAME of at most max characters
+func validSyslogName(s string, max int) bool {
+	if s == "" || len(s) > max {
+		return false
+	}
+	for _, c := range s {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// syslogSink sends every event to a syslog relay as an RFC 5424 message.  Over TCP and TLS messages are framed with
+// octet counting (RFC 6587) so events may contain any character
+type syslogSink struct {
+	options SyslogOptions
+	conn    net.Conn
+	// header is everything before the TIMESTAMP and tail everything between it and the event, neither changes
+	header string
+	tail   string
+	now    func() time.Time
+}
+
+// NewSyslogSink returns a sink that sends events to a syslog relay over UDP, TCP or TLS
+func NewSyslogSink(options SyslogOptions) (EventSink, error) {
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+	if options.Facility == "" {
+		options.Facility = defaultSyslogFacility
+	}
+	if options.AppName == "" {
+		options.AppName = defaultSyslogAppName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || !validSyslogName(hostname, 255) {
+		hostname = "-"
+	}
+	s := &syslogSink{
+		options: options,
+		header:  "<" + strconv.Itoa(syslogFacilities[options.Facility]*8+syslogSeverityInfo) + ">1 ",
+		tail:    fmt.Sprintf(" %v %v %v - %v ", hostname, options.AppName, os.Getpid(), formatStructuredData(options.StructuredData)),
+		now:     time.Now,
+	}
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// formatStructuredData returns the RFC 5424 STRUCTURED-DATA for sd, elements and parameters are sorted so every
+// message is the same
+func formatStructuredData(sd map[string]map[string]string) string {
+	if len(sd) == 0 {
+		return "-"
+	}
+	var ids []string
+	for id := range sd {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString("[" + id)
+		var names []string
+		for name := range sd[id] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteString(" " + name + "=\"" + escapeSDParam(sd[id][name]) + "\"")
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// escapeSDParam escapes the characters RFC 5424 does not allow unescaped in a PARAM-VALUE
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+func (s *syslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.options.Network == SyslogTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.options.Address, s.options.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(s.options.Network, s.options.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to syslog at %v: %w", s.options.Address, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// message returns the RFC 5424 message for an event, framed for the network
+func (s *syslogSink) message(payload []byte) []byte {
+	var msg bytes.Buffer
+	msg.WriteString(s.header)
+	msg.WriteString(s.now().UTC().Format(rfc5424Time))
+	msg.WriteString(s.tail)
+	msg.Write(payload)
+	if s.options.Network == SyslogUDP {
+		return msg.Bytes()
+	}
+	framed := make([]byte, 0, msg.Len()+8)
+	framed = strconv.AppendInt(framed, int64(msg.Len()), 10)
+	framed = append(framed, ' ')
+	return append(framed, msg.Bytes()...)
+}
+
+func (s *syslogSink) WriteEvent(payload []byte) error {
+	message := s.message(payload)
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.write(message)
+	if err == nil {
+		return nil
+	}
+	// The relay may have dropped an idle connection, connect again and retry once
+	s.conn.Close()
+	s.conn = nil
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+	err = s.write(message)
+	if err != nil {
+		return fmt.Errorf("error writing to syslog at %v: %w", s.options.Address, err)
+	}
+	return nil
+}
+
+func (s *syslogSink) write(message []byte) error {
+	err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(message)
+	return err
+}
+
+// Flush has nothing to do, syslog has no acknowledgements so a message is delivered once it is written
+func (s *syslogSink) Flush() error {
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
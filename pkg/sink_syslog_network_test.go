@@ -0,0 +1,270 @@
+package pkg
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readOctetCounted reads a single RFC 6587 octet counted message
+func readOctetCounted(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+// acceptMessages serves a single TCP or TLS connection and returns the first count octet counted messages
+func acceptMessages(t *testing.T, listener net.Listener, count int) chan []string {
+	messages := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("error accepting connection: %v", err)
+			messages <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		var got []string
+		for i := 0; i < count; i++ {
+			msg, err := readOctetCounted(r)
+			if err != nil {
+				t.Errorf("error reading message: %v", err)
+				break
+			}
+			got = append(got, msg)
+		}
+		messages <- got
+	}()
+	return messages
+}
+
+// writeTestCertificate writes a self signed certificate for 127.0.0.1 and its key as PEM files
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "syslog.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// rfc5424Event matches a message from the syslog sink with the local0 facility, the jumpcloud app name and the
+// structured data used by the tests
+var rfc5424Event = regexp.MustCompile(`^<134>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ jumpcloud \d+ - \[meta@32473 site="a\\"b\\]c" tenant="acme"\] (\{.*\})$`)
+
+var testStructuredData = map[string]map[string]string{"meta@32473": {"tenant": "acme", "site": `a"b]c`}}
+
+func checkSyslogMessage(t *testing.T, got string, want string) {
+	match := rfc5424Event.FindStringSubmatch(got)
+	if match == nil {
+		t.Errorf("syslog message = %q, want it to match %v", got, rfc5424Event)
+		return
+	}
+	if match[1] != want {
+		t.Errorf("syslog message event = %v, want %v", match[1], want)
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer conn.Close()
+	sink, err := NewSyslogSink(SyslogOptions{Network: SyslogUDP, Address: conn.LocalAddr().String(), StructuredData: testStructuredData})
+	if err != nil {
+		t.Fatalf("NewSyslogSink() error = %v", err)
+	}
+	defer sink.Close()
+	event := `{"id":"a"}`
+	if err := sink.WriteEvent([]byte(event)); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("error reading datagram: %v", err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), event)
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	messages := acceptMessages(t, listener, 2)
+	sink, err := NewSyslogSink(SyslogOptions{Network: SyslogTCP, Address: listener.Addr().String(), StructuredData: testStructuredData})
+	if err != nil {
+		t.Fatalf("NewSyslogSink() error = %v", err)
+	}
+	defer sink.Close()
+	// Octet counting keeps an event with a newline in one message
+	events := []string{`{"id":"a","note":"line one\nline two"}`, `{"id":"b"}`}
+	for _, event := range events {
+		if err := sink.WriteEvent([]byte(event)); err != nil {
+			t.Fatalf("WriteEvent() error = %v", err)
+		}
+	}
+	got := <-messages
+	if len(got) != len(events) {
+		t.Fatalf("syslog got %v messages, want %v", len(got), len(events))
+	}
+	for i := range events {
+		checkSyslogMessage(t, got[i], events[i])
+	}
+}
+
+func TestSyslogSinkTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("error loading certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	messages := acceptMessages(t, listener, 1)
+	// Open through the config so the CA file is loaded the same way as in production
+	sink, err := OutputConfig{
+		Type:           OutputSyslog,
+		Network:        SyslogTLS,
+		Address:        listener.Addr().String(),
+		StructuredData: testStructuredData,
+		CAFile:         certFile,
+	}.open()
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	defer sink.Close()
+	event := `{"id":"a"}`
+	if err := sink.WriteEvent([]byte(event)); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	got := <-messages
+	if len(got) != 1 {
+		t.Fatalf("syslog got %v messages, want 1", len(got))
+	}
+	checkSyslogMessage(t, got[0], event)
+}
+
+func TestSyslogSinkTLSUntrusted(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("error loading certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	_, err = NewSyslogSink(SyslogOptions{Network: SyslogTLS, Address: listener.Addr().String()})
+	if err == nil {
+		t.Errorf("NewSyslogSink() expected an error for a relay with an untrusted certificate")
+	}
+}
+
+func TestSyslogOptions_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options SyslogOptions
+		wantErr bool
+	}{
+		{
+			name:    "TestSyslogOptionsValid",
+			options: SyslogOptions{Network: SyslogTCP, Address: "relay:6514", Facility: "auth", AppName: "jc", StructuredData: testStructuredData},
+		},
+		{
+			name:    "TestSyslogOptionsUnknownNetwork",
+			options: SyslogOptions{Network: "carrier_pigeon", Address: "relay:514"},
+			wantErr: true,
+		},
+		{
+			name:    "TestSyslogOptionsMissingAddress",
+			options: SyslogOptions{Network: SyslogUDP},
+			wantErr: true,
+		},
+		{
+			name:    "TestSyslogOptionsAppNameWithSpace",
+			options: SyslogOptions{Network: SyslogUDP, Address: "relay:514", AppName: "jump cloud"},
+			wantErr: true,
+		},
+		{
+			name:    "TestSyslogOptionsBadStructuredDataID",
+			options: SyslogOptions{Network: SyslogUDP, Address: "relay:514", StructuredData: map[string]map[string]string{"a=b": {}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package pkg
+
+import (
+	"fmt"
+)
+
+// NewLocalSyslogSink is not supported on platforms without a local syslog daemon
+func NewLocalSyslogSink(string, string) (EventSink, error) {
+	return nil, fmt.Errorf("the syslog output is not supported on this platform")
+}
@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingSink is an EventSink that keeps events in memory and can be told to fail
+type recordingSink struct {
+	events   []string
+	writeErr error
+	flushErr error
+	flushes  int
+	// flushed is signalled on every Flush if it is set
+	flushed chan struct{}
+}
+
+func (r *recordingSink) WriteEvent(payload []byte) error {
+	if r.writeErr != nil {
+		return r.writeErr
+	}
+	r.events = append(r.events, string(payload))
+	return nil
+}
+
+func (r *recordingSink) Flush() error {
+	r.flushes++
+	if r.flushed != nil {
+		select {
+		case r.flushed <- struct{}{}:
+		default:
+		}
+	}
+	return r.flushErr
+}
+
+func (r *recordingSink) Close() error {
+	return nil
+}
+
+func TestFanOutSink(t *testing.T) {
+	failure := errors.New("unavailable")
+	tests := []struct {
+		name         string
+		required     *recordingSink
+		optional     *recordingSink
+		wantWriteErr bool
+		wantFlushErr bool
+	}{
+		{
+			name:     "TestFanOutSinkAllConfirm",
+			required: &recordingSink{},
+			optional: &recordingSink{},
+		},
+		{
+			name:         "TestFanOutSinkRequiredWriteFails",
+			required:     &recordingSink{writeErr: failure},
+			optional:     &recordingSink{},
+			wantWriteErr: true,
+		},
+		{
+			name:         "TestFanOutSinkRequiredFlushFails",
+			required:     &recordingSink{flushErr: failure},
+			optional:     &recordingSink{},
+			wantFlushErr: true,
+		},
+		{
+			name:     "TestFanOutSinkOptionalFails",
+			required: &recordingSink{},
+			optional: &recordingSink{writeErr: failure, flushErr: failure},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := NewFanOutSink(
+				SinkRoute{Name: "required", Sink: tt.required, Required: true},
+				SinkRoute{Name: "optional", Sink: tt.optional},
+			)
+			err := sink.WriteEvent([]byte(`{"id":"a"}`))
+			if (err != nil) != tt.wantWriteErr {
+				t.Errorf("WriteEvent() error = %v, wantErr %v", err, tt.wantWriteErr)
+			}
+			err = sink.Flush()
+			if (err != nil) != tt.wantFlushErr {
+				t.Errorf("Flush() error = %v, wantErr %v", err, tt.wantFlushErr)
+			}
+			if tt.wantFlushErr && !strings.Contains(err.Error(), "required") {
+				t.Errorf("Flush() error = %v, want it to name the required sink", err)
+			}
+			for _, r := range []*recordingSink{tt.required, tt.optional} {
+				if r.writeErr == nil && len(r.events) != 1 {
+					t.Errorf("WriteEvent() sink got %v events, want 1", len(r.events))
+				}
+				if r.flushes != 1 {
+					t.Errorf("Flush() sink flushed %v times, want 1", r.flushes)
+				}
+			}
+			// Failures are only reported for the events since the last flush
+			if tt.required.flushErr == nil && tt.required.writeErr == nil {
+				if err := sink.Flush(); err != nil {
+					t.Errorf("Flush() second flush error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSinkChainSharesDocument(t *testing.T) {
+	filter, err := NewEventFilter([]FilterRule{{Name: "ldap", Action: FilterExclude, Services: []string{"ldap"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := NewRedactor(RedactionConfig{Rules: []RedactionRule{{Fields: []string{"client_ip"}, Action: RedactDrop}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &recordingSink{}
+	formatted := &recordingSink{}
+	var event eventDocument
+	// The formatter must be handed the event decoded once at the top of the chain, not a copy decoded again
+	sameEvent := func(got eventDocument) ([]byte, error) {
+		if reflect.ValueOf(got).Pointer() != reflect.ValueOf(event).Pointer() {
+			t.Errorf("formatter was given a decoded copy of the event")
+		}
+		return []byte(got.str("id")), nil
+	}
+	sink := NewFilteringSink(NewRedactingSink(NewFanOutSink(
+		SinkRoute{Name: "plain", Sink: plain, Required: true},
+		SinkRoute{Name: "formatted", Sink: newFormatSink(formatted, sameEvent), Required: true},
+	), redactor), filter)
+
+	for _, payload := range []string{
+		`{"service":"sso","id":"a","client_ip":"203.0.113.9"}`,
+		`{"service":"ldap","id":"b"}`,
+	} {
+		event, err = decodeEventDocument([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeDocument(sink, event); err != nil && !errors.Is(err, ErrEventFiltered) {
+			t.Fatalf("writeDocument() error = %v", err)
+		}
+	}
+	if want := []string{`{"id":"a","service":"sso"}`}; !reflect.DeepEqual(plain.events, want) {
+		t.Errorf("writeDocument() plain output got = %v, want %v", plain.events, want)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(formatted.events, want) {
+		t.Errorf("writeDocument() formatted output got = %v, want %v", formatted.events, want)
+	}
+}
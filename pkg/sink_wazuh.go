@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultWazuhQueueSocket is where the Wazuh manager's analysisd listens for events
+	DefaultWazuhQueueSocket = "/var/ossec/queue/sockets/queue"
+	// wazuhQueueLocation is the location Wazuh reports for the events, like the path of a file it tails
+	wazuhQueueLocation = "jumpcloud"
+	// wazuhLocalfileQueue is the analysisd message queue type used by logcollector for events read from files
+	wazuhLocalfileQueue = '1'
+	// wazuhMaxMessageSize is the largest message analysisd accepts, larger datagrams are dropped
+	wazuhMaxMessageSize = 65536
+	// wazuhWriteTimeout is how long a write waits for room in the queue when analysisd falls behind
+	wazuhWriteTimeout = 30 * time.Second
+)
+
+// wazuhQueueSink sends every event straight to analysisd over its Unix datagram socket, the same way logcollector
+// delivers the lines it reads from files
+type wazuhQueueSink struct {
+	path         string
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+// NewWazuhQueueSink returns a sink that sends events to the analysisd queue socket at path, an empty path defaults
+// to /var/ossec/queue/sockets/queue
+func NewWazuhQueueSink(path string) (EventSink, error) {
+	if path == "" {
+		path = DefaultWazuhQueueSocket
+	}
+	s := &wazuhQueueSink{path: path, writeTimeout: wazuhWriteTimeout}
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *wazuhQueueSink) connect() error {
+	conn, err := net.Dial("unixgram", s.path)
+	if err != nil {
+		return fmt.Errorf("error connecting to the Wazuh queue socket: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// wazuhQueueMessage frames an event in the analysisd protocol, 1:location:event
+func wazuhQueueMessage(payload []byte) []byte {
+	message := make([]byte, 0, len(payload)+len(wazuhQueueLocation)+3)
+	message = append(message, wazuhLocalfileQueue, ':')
+	message = append(message, wazuhQueueLocation...)
+	message = append(message, ':')
+	return append(message, payload...)
+}
+
+func (s *wazuhQueueSink) WriteEvent(payload []byte) error {
+	message := wazuhQueueMessage(payload)
+	if len(message) > wazuhMaxMessageSize {
+		return fmt.Errorf("event of %v bytes is larger than the %v bytes the Wazuh queue accepts", len(message), wazuhMaxMessageSize)
+	}
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.write(message)
+	if err == nil {
+		return nil
+	}
+	// A full queue is not fixed by a new connection, fail the write so the events are collected again next run
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("the Wazuh queue did not accept the event within %v, analysisd is falling behind: %w", s.writeTimeout, err)
+	}
+	// analysisd creates a new socket when the manager restarts, connect again and retry once
+	s.conn.Close()
+	s.conn = nil
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+	err = s.write(message)
+	if err != nil {
+		return fmt.Errorf("error writing to the Wazuh queue socket: %w", err)
+	}
+	return nil
+}
+
+func (s *wazuhQueueSink) write(message []byte) error {
+	err := s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(message)
+	return err
+}
+
+// Flush has nothing to do, analysisd received each event as it was written
+func (s *wazuhQueueSink) Flush() error {
+	return nil
+}
+
+func (s *wazuhQueueSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenWazuhQueue stands in for analysisd, the socket lives in a short directory as Unix socket paths are limited
+// to around 100 characters
+func listenWazuhQueue(t *testing.T, path string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error listening on %v: %v", path, err)
+	}
+	return conn
+}
+
+func readWazuhQueue(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, wazuhMaxMessageSize+1)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading from the queue socket: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestWazuhQueueSink(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wq")
+	if err != nil {
+		t.Fatalf("error creating socket directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue")
+	listener := listenWazuhQueue(t, path)
+	sink, err := NewWazuhQueueSink(path)
+	if err != nil {
+		t.Fatalf("NewWazuhQueueSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	event := `{"jumpcloud_event_type":"sso","id":"a"}`
+	if err := sink.WriteEvent([]byte(event)); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+	if got, want := readWazuhQueue(t, listener), "1:jumpcloud:"+event; got != want {
+		t.Errorf("WriteEvent() sent = %v, want %v", got, want)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+
+	// A manager restart replaces the socket, the sink connects to the new one
+	listener.Close()
+	os.Remove(path)
+	listener = listenWazuhQueue(t, path)
+	defer listener.Close()
+	if err := sink.WriteEvent([]byte(event)); err != nil {
+		t.Fatalf("WriteEvent() after restart error = %v", err)
+	}
+	if got := readWazuhQueue(t, listener); !strings.HasPrefix(got, "1:jumpcloud:") {
+		t.Errorf("WriteEvent() after restart sent = %v", got)
+	}
+
+	// An event analysisd would drop is reported rather than silently lost
+	if err := sink.WriteEvent([]byte(strings.Repeat("x", wazuhMaxMessageSize))); err == nil {
+		t.Errorf("WriteEvent() expected an error for an oversized event")
+	}
+}
+
+func TestNewWazuhQueueSinkNoManager(t *testing.T) {
+	_, err := NewWazuhQueueSink(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Errorf("NewWazuhQueueSink() expected an error when no manager is listening")
+	}
+}
+
+func TestWazuhQueueSinkFullQueue(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wq")
+	if err != nil {
+		t.Fatalf("error creating socket directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue")
+	// Nothing reads from the listener so its receive buffer fills up like the queue of a stalled analysisd
+	listener := listenWazuhQueue(t, path)
+	defer listener.Close()
+	sink, err := NewWazuhQueueSink(path)
+	if err != nil {
+		t.Fatalf("NewWazuhQueueSink() error = %v", err)
+	}
+	defer sink.Close()
+	sink.(*wazuhQueueSink).writeTimeout = 100 * time.Millisecond
+
+	event := []byte(strings.Repeat("x", 4096))
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 100000; i++ {
+			if err := sink.WriteEvent(event); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("WriteEvent() error = %v, want a write timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WriteEvent() blocked on a full queue")
+	}
+}
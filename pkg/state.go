@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateStore is a TimeTracker that keeps checkpoints and recently emitted event IDs in a state file of its own so
+// the config file is never written by the service
+type StateStore struct {
+	// Last is the checkpoint used when all services are collected in a single query
+	Last *time.Time `json:"last,omitempty"`
+	// Checkpoints is the last time of each individually collected service
+	Checkpoints map[string]time.Time `json:"checkpoints,omitempty"`
+	// SeenEvents holds the IDs and timestamps of the events emitted inside the overlap window of each service
+	SeenEvents map[string]map[string]time.Time `json:"seen_events,omitempty"`
+	path       string
+	overlap    time.Duration
+}
+
+// OpenStateStore reads the state file for the given configuration.  If the state file does not exist yet the last
+// time is carried over from the config file so upgrading does not reset the checkpoint
+func OpenStateStore(config *ConfigurationData) (*StateStore, error) {
+	s := StateStore{
+		path:    config.StatePath(),
+		overlap: config.Overlap(),
+	}
+	contents, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.Last = config.Last
+		return &s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(contents, &s)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state file %v: %v", s.path, err)
+	}
+	return &s, nil
+}
+
+func (s *StateStore) UpdateLast(newTime time.Time) error {
+	s.Last = &newTime
+	s.pruneSeenEvents(AllServices, newTime)
+	return s.save()
+}
+
+func (s *StateStore) GetLastTime() time.Time {
+	if s.Last == nil {
+		return time.Now().Add(-time.Hour * 1)
+	}
+	return *s.Last
+}
+
+// UpdateServiceLast stores the checkpoint of a single service
+func (s *StateStore) UpdateServiceLast(service string, newTime time.Time) error {
+	if s.Checkpoints == nil {
+		s.Checkpoints = map[string]time.Time{}
+	}
+	s.Checkpoints[service] = newTime
+	s.pruneSeenEvents(service, newTime)
+	return s.save()
+}
+
+// GetServiceLastTime returns the checkpoint of a single service, a service without its own checkpoint starts from
+// the last time so switching from all services to a service list does not re-send or skip events
+func (s *StateStore) GetServiceLastTime(service string) time.Time {
+	if last, ok := s.Checkpoints[service]; ok {
+		return last
+	}
+	return s.GetLastTime()
+}
+
+// Overlap returns how far before a checkpoint events are queried again
+func (s *StateStore) Overlap() time.Duration {
+	return s.overlap
+}
+
+// SeenEvent returns true if the event with the given ID was already emitted for the service
+func (s *StateStore) SeenEvent(service string, id string) bool {
+	_, ok := s.SeenEvents[service][id]
+	return ok
+}
+
+// MarkSeen records an emitted event, it is persisted with the next checkpoint update of the service
+func (s *StateStore) MarkSeen(service string, id string, timestamp time.Time) {
+	if s.SeenEvents == nil {
+		s.SeenEvents = map[string]map[string]time.Time{}
+	}
+	if s.SeenEvents[service] == nil {
+		s.SeenEvents[service] = map[string]time.Time{}
+	}
+	s.SeenEvents[service][id] = timestamp
+}
+
+// pruneSeenEvents forgets events that are older than the overlap window of the new checkpoint, they can never be
+// returned by a query again.  Queries start at a whole second so the cutoff is truncated to one, otherwise events in
+// the fraction of a second before it would be queried again after they were forgotten
+func (s *StateStore) pruneSeenEvents(service string, checkpoint time.Time) {
+	cutoff := checkpoint.Add(-s.overlap).Truncate(time.Second)
+	for id, timestamp := range s.SeenEvents[service] {
+		if timestamp.Before(cutoff) {
+			delete(s.SeenEvents[service], id)
+		}
+	}
+}
+
+// save atomically replaces the state file, a crash part way through leaves the previous state in place
+func (s *StateStore) save() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory, syncs it and renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file on any failure, after a successful rename this is a no-op
+	defer os.Remove(f.Name())
+	err = f.Chmod(perm)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself survives a crash
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
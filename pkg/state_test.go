@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenStateStore(t *testing.T) {
+	legacyLast := time.Date(2023, 2, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		contents string
+		wantLast time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "TestOpenStateStoreMissingFileUsesConfigLast",
+			wantLast: legacyLast,
+		},
+		{
+			name:     "TestOpenStateStoreExistingFile",
+			contents: `{"last":"2023-03-01T00:00:00Z"}`,
+			wantLast: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "TestOpenStateStoreCorruptFile",
+			contents: `{"last":`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.contents != "" {
+				if err := os.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+					t.Fatalf("error writing state file: %v", err)
+				}
+			}
+			got, err := OpenStateStore(&ConfigurationData{StateFile: path, Last: &legacyLast})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OpenStateStore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !got.GetLastTime().Equal(tt.wantLast) {
+				t.Errorf("OpenStateStore() last = %v, want %v", got.GetLastTime(), tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestStateStore_UpdateServiceLast(t *testing.T) {
+	dir := t.TempDir()
+	window := Duration{Duration: 10 * time.Minute}
+	conf := &ConfigurationData{StateFile: filepath.Join(dir, "state.json"), OverlapWindow: &window}
+	s, err := OpenStateStore(conf)
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	checkpoint := time.Date(2023, 2, 15, 10, 0, 0, 0, time.UTC)
+	s.MarkSeen("sso", "old", checkpoint.Add(-time.Hour))
+	s.MarkSeen("sso", "recent", checkpoint.Add(-time.Minute))
+	err = s.UpdateServiceLast("sso", checkpoint)
+	if err != nil {
+		t.Fatalf("UpdateServiceLast() error = %v", err)
+	}
+	info, err := os.Stat(conf.StateFile)
+	if err != nil {
+		t.Fatalf("error reading state file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("UpdateServiceLast() state file permissions = %v, want 0600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading state directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("UpdateServiceLast() left temporary files behind: %v", entries)
+	}
+	got, err := OpenStateStore(conf)
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	if !got.GetServiceLastTime("sso").Equal(checkpoint) {
+		t.Errorf("GetServiceLastTime() got = %v, want %v", got.GetServiceLastTime("sso"), checkpoint)
+	}
+	if got.SeenEvent("sso", "old") {
+		t.Errorf("UpdateServiceLast() kept an event older than the overlap window")
+	}
+	if !got.SeenEvent("sso", "recent") {
+		t.Errorf("UpdateServiceLast() did not persist an event inside the overlap window")
+	}
+}
+
+func TestStateStore_pruneSeenEventsWholeSecond(t *testing.T) {
+	window := Duration{Duration: 10 * time.Minute}
+	s, err := OpenStateStore(&ConfigurationData{StateFile: filepath.Join(t.TempDir(), "state.json"), OverlapWindow: &window})
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	// The next query starts at 09:50:00 because the start time is sent without fractional seconds
+	checkpoint := time.Date(2023, 2, 15, 10, 0, 0, 750*int(time.Millisecond), time.UTC)
+	s.MarkSeen("sso", "same-second", time.Date(2023, 2, 15, 9, 50, 0, 250*int(time.Millisecond), time.UTC))
+	s.MarkSeen("sso", "second-before", time.Date(2023, 2, 15, 9, 49, 59, 999*int(time.Millisecond), time.UTC))
+	err = s.UpdateServiceLast("sso", checkpoint)
+	if err != nil {
+		t.Fatalf("UpdateServiceLast() error = %v", err)
+	}
+	if !s.SeenEvent("sso", "same-second") {
+		t.Errorf("UpdateServiceLast() forgot an event the next query returns again")
+	}
+	if s.SeenEvent("sso", "second-before") {
+		t.Errorf("UpdateServiceLast() kept an event before the next query")
+	}
+}
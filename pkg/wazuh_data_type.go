@@ -2,40 +2,114 @@ package pkg
 
 // Simple version to text JSON strings for Wazuh to ingest, might need to customize these later
 
-import "encoding/json"
+import (
+	"encoding/json"
+)
 
-func (d *JumpCloudSystemEvent) convertToWazuhString() string {
+// rawEvent keeps the original JSON object an event was decoded from so fields that are not modelled by the typed
+// structs are not lost on the way to Wazuh
+type rawEvent struct {
+	original eventDocument
+}
+
+// setOriginal stores the original event, decoded once by the stream decoder
+func (r *rawEvent) setOriginal(original eventDocument) {
+	r.original = original
+}
+
+// withEventType returns the original event with its jumpcloud_event_type set.  The typed fields were filled from the
+// original so it already holds every field JumpCloud sent exactly as it was sent.  An event built in code rather than
+// decoded has no original and is made from typed, a pointer to the typed event
+func (r *rawEvent) withEventType(typed interface{}, eventType string) eventDocument {
+	if r.original == nil {
+		r.original = eventDocument{}
+		if b, err := json.Marshal(typed); err == nil {
+			if decoded, err := decodeEventDocument(b); err == nil {
+				r.original = decoded
+			}
+		}
+	}
+	r.original["jumpcloud_event_type"] = eventType
+	return r.original
+}
+
+// wazuhPayload returns the event as the single line of JSON the Wazuh rules expect
+func wazuhPayload(x JumpCloudEvent) ([]byte, error) {
+	return x.wazuhDocument().encode()
+}
+
+func (d *JumpCloudSystemEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "system"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
 }
 
-func (d *JumpCloudLDAPEvent) convertToWazuhString() string {
+func (d *JumpCloudLDAPEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "ldap"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
 }
 
-func (d *JumpCloudDirectoryEvent) convertToWazuhString() string {
+func (d *JumpCloudDirectoryEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "directory"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
 }
 
-func (d *JumpCloudRadiusEvent) convertToWazuhString() string {
+func (d *JumpCloudRadiusEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "radius"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
 }
 
-func (d *JumpCloudSSOEvent) convertToWazuhString() string {
+func (d *JumpCloudSSOEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "sso"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
 }
 
-func (d *JumpCloudAdminEvent) convertToWazuhString() string {
+func (d *JumpCloudAdminEvent) wazuhDocument() eventDocument {
 	d.JumpCloudEventType = "admin"
-	b, _ := json.Marshal(d)
-	return string(b)
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudMDMEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "mdm"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudPasswordManagerEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "password_manager"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudSoftwareEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "software"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudAlertEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "alerts"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudObjectStorageEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "object_storage"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudSaaSAppManagementEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "saas_app_management"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+func (d *JumpCloudAccessManagementEvent) wazuhDocument() eventDocument {
+	d.JumpCloudEventType = "access_management"
+	return d.withEventType(d, d.JumpCloudEventType)
+}
+
+// wazuhDocument returns the original event with jumpcloud_event_type added unless it already has one
+func (d *JumpCloudRawEvent) wazuhDocument() eventDocument {
+	if d.original == nil {
+		d.original = eventDocument{}
+	}
+	if _, ok := d.original["jumpcloud_event_type"]; !ok {
+		d.original["jumpcloud_event_type"] = d.JumpCloudEventType
+	}
+	return d.original
 }
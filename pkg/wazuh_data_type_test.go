@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_wazuhDocumentMatchesMarshal(t *testing.T) {
+	payload, err := os.ReadFile("../test_data/mixed_events.json")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(payload, &elements); err != nil {
+		t.Fatalf("error decoding test data: %v", err)
+	}
+	elements = append(elements,
+		json.RawMessage(`{"service":"directory","id":"dir-9","timestamp":"2023-02-15T10:00:00.123456789Z",`+
+			`"geoip":{"latitude":1e21,"longitude":0.0000001,"city":"Nowhere"},"initiated_by":{"username":"<jdoe&co>","extra":[1,{"a":null}]}}`),
+		// Fields of any type hold integers too large for a float64
+		json.RawMessage(`{"service":"software","id":"sw-9","timestamp":"2023-02-15T10:00:00Z",`+
+			`"changes":[{"field":"size","from":9007199254740993,"to":12345678901234567890}]}`),
+	)
+	for _, element := range elements {
+		original, err := decodeEventDocument(element)
+		if err != nil {
+			t.Fatalf("decodeEventDocument() error = %v", err)
+		}
+		service := original.str("service")
+		x := decodeTypedEvent(service, original)
+		if x == nil {
+			t.Errorf("decodeTypedEvent() did not decode %s", element)
+			continue
+		}
+		// The typed fields are filled from the decoded object the same way json.Unmarshal fills them from the JSON
+		want, _ := newTypedEvent(service)
+		d := json.NewDecoder(bytes.NewReader(element))
+		d.UseNumber()
+		if err := d.Decode(want); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		want.setOriginal(original)
+		if !reflect.DeepEqual(x, want) {
+			t.Errorf("decodeTypedEvent() got = %+v, want %+v", x, want)
+		}
+		// The Wazuh event is everything JumpCloud sent, unchanged, with jumpcloud_event_type added
+		got, err := wazuhPayload(x)
+		if err != nil {
+			t.Fatalf("wazuhPayload() error = %v", err)
+		}
+		document, _ := decodeEventDocument(element)
+		document["jumpcloud_event_type"] = jumpCloudEventTypeForService(service)
+		wantPayload, _ := json.Marshal(document)
+		if !bytes.Equal(got, wantPayload) {
+			t.Errorf("wazuhPayload() got = %s, want %s", got, wantPayload)
+		}
+		if service == "software" && original.str("id") == "sw-9" &&
+			!bytes.Contains(got, []byte(`"from":9007199254740993,"to":12345678901234567890`)) {
+			t.Errorf("wazuhPayload() changed large integers, got = %s", got)
+		}
+	}
+}